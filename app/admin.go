@@ -0,0 +1,198 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/logger"
+)
+
+var errJobNotFound = errors.New("job not found")
+
+// Jobs returns the status of every running job, sorted by name.
+func (a *App) Jobs() []JobStatus {
+	st := a.state()
+
+	jobs := make([]JobStatus, 0, len(st.jobs))
+	for _, job := range st.jobs {
+		jobs = append(jobs, job.Status())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+
+	return jobs
+}
+
+func (a *App) job(name string) (*Job, error) {
+	job, ok := a.state().jobs[name]
+	if !ok {
+		return nil, errJobNotFound
+	}
+	return job, nil
+}
+
+// adminHandler serves the admin API:
+//
+//	GET  /api/jobs                list jobs and their status
+//	GET  /api/jobs/<name>         show one job
+//	POST /api/jobs/<name>/run     run a job now
+//	POST /api/jobs/<name>/pause   stop the scheduled runs of a job
+//	POST /api/jobs/<name>/resume  start them again
+//	GET  /api/config              show the effective config, redacted
+//	GET  /api/loglevel            show the global log level
+//	PUT  /api/loglevel            change it, with a body like {"level":"debug"}
+//
+// A "/" in a job name is escaped as %2F. Without server.adminToken only GET
+// requests are served.
+func (a *App) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs", a.handleJobs)
+	mux.HandleFunc("/api/jobs/", a.handleJob)
+	mux.HandleFunc("/api/config", a.handleConfig)
+	mux.HandleFunc("/api/loglevel", a.handleLogLevel)
+
+	return a.authorize(mux)
+}
+
+// authorize checks the bearer token when server.adminToken is set, and
+// refuses everything but GET when it is not.
+func (a *App) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := a.state().config.Server.AdminToken
+
+		if token == "" && r.Method != http.MethodGet {
+			writeError(w, http.StatusForbidden, errors.New("server.adminToken must be set to change anything"))
+			return
+		}
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *App) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.Jobs())
+}
+
+func (a *App) handleJob(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.EscapedPath(), "/api/jobs/")
+
+	action := ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, action = name[:i], name[i+1:]
+	}
+	name, err := url.PathUnescape(name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := a.job(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, errors.Wrap(err, name))
+		return
+	}
+
+	want := http.MethodPost
+	if action == "" {
+		want = http.MethodGet
+	}
+	if r.Method != want {
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	switch action {
+	case "":
+	case "run":
+		job.Trigger()
+		slog.Info("job run requested", "name", name)
+	case "pause":
+		job.SetPaused(true)
+		slog.Info("job paused", "name", name)
+	case "resume":
+		job.SetPaused(false)
+		slog.Info("job resumed", "name", name)
+	default:
+		writeError(w, http.StatusNotFound, errors.Errorf("unknown action %s", action))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job.Status())
+}
+
+func (a *App) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	raw, err := a.state().config.Dump()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(raw)
+}
+
+// logLevel is the body of /api/loglevel.
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func (a *App) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var req logLevel
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid body"))
+			return
+		}
+		l, err := logger.ParseLevel(req.Level)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		logger.SetLevel(l)
+		slog.Warn("log level changed", "level", l.String())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, logLevel{Level: logger.Level().String()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Warn("failed to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
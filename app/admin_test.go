@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wanmail/alert-fetcher/checkpoint"
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/sink"
+)
+
+func TestAdminAPI(t *testing.T) {
+	es, am, alerts := fakeServers(t, `{"host":{"name":"web-1"}}`)
+
+	job := testJob("job1", "am")
+	job.SourceConfig.SourceConfig = json.RawMessage(`{"address":"` + es.URL + `","index":"logs","query":"{}","max":10}`)
+	nested := testJob("team/job2", "am")
+
+	checkpoints, _ := checkpoint.Open("")
+	a := NewApp(context.Background(), Stores{Checkpoints: checkpoints})
+	err := a.Apply(config.AppConfig{
+		Server: config.ServerConfig{AdminToken: "s3cret"},
+		Sink:   map[string]sink.SinkConfig{"am": testSink(strings.TrimPrefix(am.URL, "http://"))},
+		Job:    []config.JobConfig{job, nested},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown(time.Second)
+
+	srv := httptest.NewServer(a.Handler())
+	defer srv.Close()
+
+	call := func(method string, path string, token string) (int, string) {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		code   int
+		want   string
+	}{
+		{"Missing token", "GET", "/api/jobs", "", http.StatusUnauthorized, "unauthorized"},
+		{"List jobs", "GET", "/api/jobs", "s3cret", http.StatusOK, `"name": "job1"`},
+		{"Unknown job", "GET", "/api/jobs/nope", "s3cret", http.StatusNotFound, "job not found"},
+		{"Wrong method", "GET", "/api/jobs/job1/run", "s3cret", http.StatusMethodNotAllowed, "not allowed"},
+		{"Pause", "POST", "/api/jobs/job1/pause", "s3cret", http.StatusOK, `"paused": true`},
+		{"Run while paused", "POST", "/api/jobs/job1/run", "s3cret", http.StatusOK, `"paused": true`},
+		{"Resume", "POST", "/api/jobs/job1/resume", "s3cret", http.StatusOK, `"paused": false`},
+		{"Config is redacted", "GET", "/api/config", "s3cret", http.StatusOK, `"adminToken": "******"`},
+		{"Log level", "GET", "/api/loglevel", "s3cret", http.StatusOK, `"level": "INFO"`},
+		{"Escaped job name", "POST", "/api/jobs/team%2Fjob2/pause", "s3cret", http.StatusOK, `"name": "team/job2"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, body := call(test.method, test.path, test.token)
+			if code != test.code || !strings.Contains(body, test.want) {
+				t.Errorf("Unexpected result. Got: %d %s, Want: %d %s", code, body, test.code, test.want)
+			}
+		})
+	}
+
+	select {
+	case <-alerts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Triggered run sent no alert")
+	}
+}
+
+func TestAdminAPIReadOnly(t *testing.T) {
+	checkpoints, _ := checkpoint.Open("")
+	a := NewApp(context.Background(), Stores{Checkpoints: checkpoints})
+	if err := a.Apply(config.AppConfig{Sink: map[string]sink.SinkConfig{"am": testSink("am:9093")}, Job: []config.JobConfig{testJob("job1", "am")}}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown(time.Second)
+
+	srv := httptest.NewServer(a.Handler())
+	defer srv.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/api/jobs/job1", http.StatusOK},
+		{"POST", "/api/jobs/job1/pause", http.StatusForbidden},
+		{"PUT", "/api/loglevel", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, srv.URL+test.path, strings.NewReader(`{"level":"debug"}`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Errorf("Unexpected result for %s %s. Got: %v, Want: %v", test.method, test.path, resp.StatusCode, test.code)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/audit"
+	"github.com/wanmail/alert-fetcher/checkpoint"
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/deadletter"
+	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/metrics"
+)
+
+// Stores holds the on-disk state an app keeps across restarts.
+type Stores struct {
+	// Checkpoints is where jobs resume from and save to.
+	Checkpoints *checkpoint.Store
+
+	// DeadLetters receives messages sinks gave up on. If nil they are
+	// dropped.
+	DeadLetters *deadletter.Store
+
+	// Audit records what happened to every message. If nil nothing is
+	// recorded.
+	Audit *audit.Log
+
+	// OutboxDir holds the write-ahead log of every sink. If empty, queued
+	// messages are lost when the process dies.
+	OutboxDir string
+}
+
+// App runs the jobs and sinks of a config and moves them to a new config
+// on reload.
+type App struct {
+	// mu serialises Apply and Shutdown, which can hold it for as long as
+	// sinks take to drain. Readers use the published state instead.
+	mu     sync.Mutex
+	ctx    context.Context
+	config config.AppConfig
+	stores Stores
+
+	// applied is set once a config has been applied
+	applied bool
+
+	sinks map[string]*sinkRunner
+	jobs  map[string]*Job
+
+	published atomic.Pointer[appState]
+}
+
+// appState is a snapshot of what an app runs, for the admin API, health
+// checks and metrics.
+type appState struct {
+	config  config.AppConfig
+	applied bool
+	sinks   map[string]*sinkRunner
+	jobs    map[string]*Job
+}
+
+// NewApp returns an app whose jobs run until ctx is cancelled. Cancelling
+// ctx also stops sinks from retrying.
+func NewApp(ctx context.Context, stores Stores) *App {
+	a := &App{
+		ctx:    ctx,
+		stores: stores,
+		sinks:  make(map[string]*sinkRunner),
+		jobs:   make(map[string]*Job),
+	}
+	a.publish()
+	return a
+}
+
+// publish snapshots the jobs, sinks and config of a. It is called with mu
+// held.
+func (a *App) publish() {
+	st := &appState{
+		config:  a.config,
+		applied: a.applied,
+		sinks:   make(map[string]*sinkRunner, len(a.sinks)),
+		jobs:    make(map[string]*Job, len(a.jobs)),
+	}
+	for name, s := range a.sinks {
+		st.sinks[name] = s
+	}
+	for name, job := range a.jobs {
+		st.jobs[name] = job
+	}
+	a.published.Store(st)
+}
+
+// state returns the last published snapshot. It never waits for Apply.
+func (a *App) state() *appState {
+	return a.published.Load()
+}
+
+// Reload reads the config at path and applies it. An invalid config is
+// rejected and the running one is kept.
+func (a *App) Reload(path string) error {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		reloaded(false)
+		slog.Error("config reload rejected", "path", path, "error", err)
+		return err
+	}
+
+	if err := a.Apply(cfg); err != nil {
+		reloaded(false)
+		slog.Error("config reload failed", "path", path, "error", err)
+		return err
+	}
+
+	reloaded(true)
+	slog.Info("config reload success", "path", path)
+	return nil
+}
+
+// reloaded records the outcome of a config load.
+func reloaded(ok bool) {
+	if !ok {
+		metrics.Reloads.WithLabelValues("failure").Inc()
+		metrics.ReloadSuccess.Set(0)
+		return
+	}
+
+	metrics.Reloads.WithLabelValues("success").Inc()
+	metrics.ReloadSuccess.Set(1)
+	metrics.ReloadTime.SetToCurrentTime()
+}
+
+// Apply moves the app to cfg, touching only the sinks and jobs that
+// changed. Jobs that are restarted keep their checkpoint. Everything new is
+// built, and the queues of new sinks opened, before anything is stopped. If
+// the queue of a replaced sink then fails to open, or a sink or job fails to
+// start, whatever was started is stopped and the previous sinks and jobs
+// are started again, so on error the running config is kept.
+func (a *App) Apply(cfg config.AppConfig) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer a.publish()
+
+	sinks := make(map[string]*sinkRunner)
+	changedSinks := make(map[string]bool)
+	for name, scfg := range cfg.Sink {
+		if old, ok := a.sinks[name]; ok && reflect.DeepEqual(old.config, scfg) {
+			continue
+		}
+		s, err := newSinkRunner(name, scfg, a.stores)
+		if err != nil {
+			return errors.Wrapf(err, "sink %s", name)
+		}
+		sinks[name] = s
+		changedSinks[name] = true
+	}
+	for name := range a.sinks {
+		if _, ok := cfg.Sink[name]; !ok {
+			changedSinks[name] = true
+		}
+	}
+
+	// every job is rebuilt when the shared templates change
+	sameTemplates := reflect.DeepEqual(a.config.SharedTemplates(), cfg.SharedTemplates())
+
+	jobs := make(map[string]*Job)
+	for _, jobcfg := range cfg.Job {
+		if old, ok := a.jobs[jobcfg.Name]; ok && old.config.Equal(jobcfg) && sameTemplates && !usesAny(jobcfg.Sink, changedSinks) {
+			continue
+		}
+		job, err := NewJob(jobcfg, cfg.SharedTemplates())
+		if err != nil {
+			closeJobs(jobs)
+			return errors.Wrapf(err, "job %s in %s", jobcfg.Name, jobcfg.File)
+		}
+		job.checkpoints = a.stores.Checkpoints
+		jobs[jobcfg.Name] = job
+	}
+
+	// sinks that replace a running one can only open their queue once the
+	// old runner has let go of its files
+	opened := make(map[string]*sinkRunner)
+	discard := func() {
+		for _, s := range opened {
+			s.discard()
+		}
+	}
+	for name, s := range sinks {
+		if _, running := a.sinks[name]; running {
+			continue
+		}
+		if err := s.open(); err != nil {
+			discard()
+			closeJobs(jobs)
+			return errors.Wrapf(err, "sink %s", name)
+		}
+		opened[name] = s
+	}
+
+	// stop jobs that are removed or replaced before the sinks they write to
+	stoppedJobs := make(map[string]*Job)
+	for name, job := range a.jobs {
+		if _, replaced := jobs[name]; replaced || !hasJob(cfg, name) {
+			job.Stop()
+			delete(a.jobs, name)
+			stoppedJobs[name] = job
+			slog.Info("job stopped", "name", name)
+		}
+	}
+
+	stoppedSinks := make(map[string]*sinkRunner)
+	for name := range changedSinks {
+		if old, ok := a.sinks[name]; ok {
+			old.stop()
+			delete(a.sinks, name)
+			stoppedSinks[name] = old
+			slog.Info("sink stopped", "name", name)
+		}
+	}
+
+	for name, s := range sinks {
+		if _, ok := opened[name]; ok {
+			continue
+		}
+		if err := s.open(); err != nil {
+			discard()
+			closeJobs(jobs)
+			a.restore(stoppedSinks, stoppedJobs)
+			return errors.Wrapf(err, "sink %s", name)
+		}
+		opened[name] = s
+	}
+
+	logger.SetSecrets(cfg.Secrets())
+	if !reflect.DeepEqual(a.config.Log, cfg.Log) {
+		if err := logger.InitLogger(cfg.Log); err != nil {
+			slog.Error("failed to apply log config, keeping the previous one", "error", err)
+		}
+	}
+
+	var errs []error
+	for name, s := range sinks {
+		if err := s.start(a.ctx); err != nil {
+			errs = append(errs, errors.Wrapf(err, "sink %s", name))
+			continue
+		}
+		a.sinks[name] = s
+		slog.Info("sink init success", "name", name)
+	}
+
+	queues := a.queues()
+	for name, job := range jobs {
+		if old, ok := stoppedJobs[name]; ok {
+			job.setCheckpoint(old.Checkpoint())
+			job.SetPaused(old.Paused())
+		}
+		if len(errs) > 0 {
+			continue
+		}
+		if err := job.Start(a.ctx, queues); err != nil {
+			errs = append(errs, errors.Wrapf(err, "job %s in %s", name, job.config.File))
+			continue
+		}
+		a.jobs[name] = job
+		slog.Info("job start success", "name", name)
+	}
+
+	if len(errs) > 0 {
+		a.rollback(sinks, jobs, stoppedSinks, stoppedJobs)
+		return errors.Errorf("failed to start: %v", errs)
+	}
+	closeJobs(stoppedJobs)
+
+	for _, jobcfg := range a.config.Job {
+		if !hasJob(cfg, jobcfg.Name) {
+			metrics.DeleteJob(jobcfg.Name)
+		}
+	}
+	for name := range a.config.Sink {
+		if _, ok := cfg.Sink[name]; !ok {
+			metrics.DeleteSink(name)
+		}
+	}
+
+	a.config = cfg
+	a.applied = true
+
+	return nil
+}
+
+// rollback stops the sinks and jobs a failed Apply started and starts the
+// ones it stopped again. Restored jobs resume from where their replacement
+// got to.
+func (a *App) rollback(sinks map[string]*sinkRunner, jobs map[string]*Job, stoppedSinks map[string]*sinkRunner, stoppedJobs map[string]*Job) {
+	for name, job := range jobs {
+		if a.jobs[name] == job {
+			job.Stop()
+			delete(a.jobs, name)
+		}
+		if old, ok := stoppedJobs[name]; ok {
+			old.setCheckpoint(job.Checkpoint())
+		}
+		job.Close()
+	}
+	for name, s := range sinks {
+		if a.sinks[name] == s {
+			s.stop()
+			delete(a.sinks, name)
+		} else {
+			s.discard()
+		}
+	}
+
+	a.restore(stoppedSinks, stoppedJobs)
+}
+
+// restore starts the sinks and jobs a failed Apply stopped again.
+func (a *App) restore(sinks map[string]*sinkRunner, jobs map[string]*Job) {
+	for name, s := range sinks {
+		if err := s.start(a.ctx); err != nil {
+			slog.Error("failed to restore sink", "name", name, "error", err)
+			continue
+		}
+		a.sinks[name] = s
+		slog.Info("sink restored", "name", name)
+	}
+
+	queues := a.queues()
+	for name, job := range jobs {
+		if err := job.Start(a.ctx, queues); err != nil {
+			slog.Error("failed to restore job", "name", name, "error", err)
+			continue
+		}
+		a.jobs[name] = job
+		slog.Info("job restored", "name", name)
+	}
+}
+
+func (a *App) queues() map[string]*queue {
+	queues := make(map[string]*queue, len(a.sinks))
+	for name, s := range a.sinks {
+		queues[name] = s.queue
+	}
+	return queues
+}
+
+// Shutdown stops every job once its current tick is done, then drains the
+// sinks and saves the checkpoints. It gives up waiting after timeout and
+// returns an error; checkpoints are saved either way.
+func (a *App) Shutdown(timeout time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var wg sync.WaitGroup
+		for name, job := range a.jobs {
+			wg.Add(1)
+			go func(name string, job *Job) {
+				defer wg.Done()
+				job.Stop()
+				job.Close()
+				slog.Info("job stopped", "name", name)
+			}(name, job)
+		}
+		wg.Wait()
+
+		for name, s := range a.sinks {
+			wg.Add(1)
+			go func(name string, s *sinkRunner) {
+				defer wg.Done()
+				s.stop()
+				slog.Info("sink stopped", "name", name)
+			}(name, s)
+		}
+		wg.Wait()
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		err = errors.Errorf("shutdown timed out after %s", timeout)
+	}
+
+	if ferr := a.stores.Checkpoints.Flush(); ferr != nil && err == nil {
+		err = ferr
+	}
+
+	return err
+}
+
+func closeJobs(jobs map[string]*Job) {
+	for _, job := range jobs {
+		job.Close()
+	}
+}
+
+func usesAny(names []string, set map[string]bool) bool {
+	for _, n := range names {
+		if set[n] {
+			return true
+		}
+	}
+	return false
+}
+
+func hasJob(cfg config.AppConfig, name string) bool {
+	for _, j := range cfg.Job {
+		if j.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wanmail/alert-fetcher/checkpoint"
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/metrics"
+	"github.com/wanmail/alert-fetcher/sink"
+	"github.com/wanmail/alert-fetcher/source"
+)
+
+func testJob(name string, sinks ...string) config.JobConfig {
+	return config.JobConfig{
+		Name:     name,
+		Duration: 3600,
+		Type:     "stream",
+		Labels:   map[string]string{"host": "host.name"},
+		SourceConfig: source.SourceConfig{
+			SourceType:   "elasticsearch",
+			SourceConfig: json.RawMessage(`{"address":"http://localhost:9200","index":"logs","query":"{}","max":10}`),
+		},
+		Sink: sinks,
+	}
+}
+
+func testSink(host string) sink.SinkConfig {
+	return sink.SinkConfig{
+		SinkType:   "alertmanager",
+		SinkConfig: json.RawMessage(`{"host":"` + host + `"}`),
+	}
+}
+
+func TestAppApply(t *testing.T) {
+	checkpoints, _ := checkpoint.Open("")
+	a := NewApp(context.Background(), Stores{Checkpoints: checkpoints})
+
+	cfg := config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am1": testSink("am1:9093"), "am2": testSink("am2:9093")},
+		Job:  []config.JobConfig{testJob("same", "am1"), testJob("changed", "am1"), testJob("resink", "am2"), testJob("removed", "am1")},
+	}
+	if err := a.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	before := make(map[string]*Job)
+	for name, j := range a.jobs {
+		before[name] = j
+	}
+	checkpoint := time.Now().Add(-time.Hour)
+	a.jobs["changed"].setCheckpoint(checkpoint)
+
+	changed := testJob("changed", "am1")
+	changed.StaticLabels = map[string]string{"team": "sec"}
+	next := config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am1": testSink("am1:9093"), "am2": testSink("other:9093")},
+		Job:  []config.JobConfig{testJob("same", "am1"), changed, testJob("resink", "am2"), testJob("added", "am1")},
+	}
+	metrics.Hits.WithLabelValues("removed").Inc()
+	if err := a.Apply(next); err != nil {
+		t.Fatal(err)
+	}
+
+	if metrics.Hits.DeleteLabelValues("removed") {
+		t.Errorf("Series of a removed job were kept")
+	}
+	if a.jobs["same"] != before["same"] {
+		t.Errorf("Unchanged job was restarted")
+	}
+	if a.jobs["changed"] == before["changed"] {
+		t.Errorf("Changed job was not restarted")
+	}
+	if got := a.jobs["changed"].Checkpoint(); !got.Equal(checkpoint) {
+		t.Errorf("Unexpected checkpoint. Got: %v, Want: %v", got, checkpoint)
+	}
+	if a.jobs["resink"] == before["resink"] {
+		t.Errorf("Job using a changed sink was not restarted")
+	}
+	if _, ok := a.jobs["removed"]; ok {
+		t.Errorf("Removed job is still running")
+	}
+	if _, ok := a.jobs["added"]; !ok {
+		t.Errorf("Added job is not running")
+	}
+
+	if err := a.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppApplyRollback(t *testing.T) {
+	dir := t.TempDir()
+	checkpoints, _ := checkpoint.Open("")
+	a := NewApp(context.Background(), Stores{Checkpoints: checkpoints, OutboxDir: dir})
+
+	cfg := config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am1": testSink("am1:9093")},
+		Job:  []config.JobConfig{testJob("job1", "am1")},
+	}
+	if err := a.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the outbox of the added sink can't be opened, nothing is stopped
+	if err := os.Mkdir(outboxPath(dir, "am2"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	added := config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am1": testSink("other:9093"), "am2": testSink("am2:9093")},
+		Job:  []config.JobConfig{testJob("job1", "am1", "am2")},
+	}
+
+	// the spill of the changed sink can't be opened once the old one is
+	// stopped, so the old one is started again
+	spilled := testSink("other:9093")
+	spilled.Queue = sink.QueueConfig{Overflow: sink.OverflowSpill, SpillPath: filepath.Join(dir, "missing", "am1.spill")}
+	changed := config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am1": spilled},
+		Job:  []config.JobConfig{testJob("job1", "am1")},
+	}
+
+	for _, next := range []config.AppConfig{added, changed} {
+		if err := a.Apply(next); err == nil {
+			t.Fatal("Expected an error")
+		}
+
+		if !reflect.DeepEqual(a.config, cfg) {
+			t.Errorf("Running config was replaced")
+		}
+		s, ok := a.sinks["am1"]
+		if !ok {
+			t.Fatal("Sink am1 is not running")
+		}
+		if !reflect.DeepEqual(s.config, cfg.Sink["am1"]) {
+			t.Errorf("Unexpected sink config. Got: %v, Want: %v", s.config, cfg.Sink["am1"])
+		}
+		if _, ok := a.sinks["am2"]; ok {
+			t.Errorf("Sink am2 is running")
+		}
+		job, ok := a.jobs["job1"]
+		if !ok {
+			t.Fatal("Job job1 is not running")
+		}
+		if job.sink["am1"] != s.queue {
+			t.Errorf("Job job1 does not write to the running sink")
+		}
+	}
+
+	if err := a.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppApplyStartFailure(t *testing.T) {
+	checkpoints, _ := checkpoint.Open("")
+	a := NewApp(context.Background(), Stores{Checkpoints: checkpoints})
+
+	cfg := config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am1": testSink("am1:9093")},
+		Job:  []config.JobConfig{testJob("job1", "am1"), testJob("job2", "am1")},
+	}
+	if err := a.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	before := map[string]*Job{"job1": a.jobs["job1"], "job2": a.jobs["job2"]}
+
+	// job1 starts, job2 can't find its sink
+	changed := testJob("job1", "am1")
+	changed.StaticLabels = map[string]string{"team": "sec"}
+	next := config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am1": testSink("am1:9093")},
+		Job:  []config.JobConfig{changed, testJob("job2", "am1", "missing")},
+	}
+	if err := a.Apply(next); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if !reflect.DeepEqual(a.config, cfg) {
+		t.Errorf("Running config was replaced")
+	}
+	for name, job := range before {
+		if a.jobs[name] != job {
+			t.Errorf("Job %s was not restored", name)
+		}
+		select {
+		case <-job.done:
+			t.Errorf("Job %s is not running", name)
+		default:
+		}
+	}
+
+	if err := a.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeServers starts an Elasticsearch returning hits on every search and an
+// Alertmanager recording the alerts posted to it.
+func fakeServers(t *testing.T, hits ...string) (es *httptest.Server, am *httptest.Server, alerts chan string) {
+	t.Helper()
+
+	es = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		docs := make([]string, 0, len(hits))
+		for _, h := range hits {
+			docs = append(docs, `{"_index":"logs","_id":"1","_source":`+h+`}`)
+		}
+		fmt.Fprintf(w, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},"hits":{"total":{"value":%d,"relation":"eq"},"hits":[%s]}}`, len(hits), strings.Join(docs, ","))
+	}))
+	t.Cleanup(es.Close)
+
+	alerts = make(chan string, 100)
+	am = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		alerts <- string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(am.Close)
+
+	return es, am, alerts
+}
+
+func TestJobTickRetry(t *testing.T) {
+	var mu sync.Mutex
+	var windows []string
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+
+		var body struct {
+			Query struct {
+				Bool struct {
+					Must []map[string]map[string]map[string]string `json:"must"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		windows = append(windows, body.Query.Bool.Must[1]["range"]["@timestamp"]["gte"])
+
+		// the first search fails
+		if len(windows) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`)
+	}))
+	defer es.Close()
+
+	cfg := testJob("job1")
+	cfg.SourceConfig.SourceConfig = json.RawMessage(`{"address":"` + es.URL + `","index":"logs","query":"{}","max":10}`)
+	job, err := NewJob(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+	job.checkpoints, _ = checkpoint.Open("")
+
+	from := time.Now().Add(-time.Hour).Truncate(time.Second)
+	job.setCheckpoint(from)
+	for i := 0; i < 3; i++ {
+		job.tick(context.Background())
+	}
+
+	want := from.Format(time.RFC3339)
+	if len(windows) != 3 || windows[0] != want || windows[1] != want || windows[2] == want {
+		t.Errorf("Unexpected windows. Got: %v, Want: %s twice then a later one", windows, want)
+	}
+}
+
+func TestAppShutdown(t *testing.T) {
+	es, am, alerts := fakeServers(t, `{"host":{"name":"web-1"}}`)
+
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	checkpoints, err := checkpoint.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := testJob("job1", "am")
+	job.Duration = 1
+	job.SourceConfig.SourceConfig = json.RawMessage(`{"address":"` + es.URL + `","index":"logs","query":"{}","max":10}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := NewApp(ctx, Stores{Checkpoints: checkpoints})
+	err = a.Apply(config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am": testSink(strings.TrimPrefix(am.URL, "http://"))},
+		Job:  []config.JobConfig{job},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case alert := <-alerts:
+		if !strings.Contains(alert, `"host":"web-1"`) {
+			t.Errorf("Unexpected alert: %s", alert)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No alert was sent")
+	}
+
+	err = a.Shutdown(5 * time.Second)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := checkpoint.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved.Get("job1"); !ok {
+		t.Errorf("Checkpoint was not saved")
+	}
+}
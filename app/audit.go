@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/audit"
+	"github.com/wanmail/alert-fetcher/config"
+)
+
+// labelFlags collects repeated -label name=value flags.
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return errors.Errorf("invalid label %q, want name=value", s)
+	}
+	l[k] = v
+	return nil
+}
+
+// timeFlag is an RFC 3339 time flag.
+type timeFlag struct {
+	t *time.Time
+}
+
+func (f timeFlag) String() string {
+	if f.t == nil || f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f timeFlag) Set(s string) error {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+	*f.t = t
+	return nil
+}
+
+// Audit prints the audit log records of a config matching the given
+// filters as JSON lines.
+func Audit(args []string) {
+	filter := audit.Filter{Labels: labelFlags{}}
+
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	path := fs.String("config", "config.json", "config file or directory path")
+	fs.StringVar(&filter.Job, "job", "", "only records of this job")
+	fs.StringVar(&filter.Sink, "sink", "", "only records of this sink")
+	fs.StringVar(&filter.Outcome, "outcome", "", "only records with this outcome: sent, failed, dead-lettered or dropped")
+	fs.Var(labelFlags(filter.Labels), "label", "only records with this label, as name=value; may be repeated")
+	fs.Var(timeFlag{&filter.From}, "from", "only records at or after this RFC 3339 time")
+	fs.Var(timeFlag{&filter.To}, "to", "only records before this RFC 3339 time")
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.Audit.Path == "" {
+		fmt.Fprintln(os.Stderr, "audit.path is not set in the config")
+		os.Exit(1)
+	}
+
+	if err := queryAudit(cfg.Audit.Path, filter, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func queryAudit(path string, filter audit.Filter, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return audit.Query(path, filter, func(r audit.Record) error {
+		return enc.Encode(r)
+	})
+}
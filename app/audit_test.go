@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wanmail/alert-fetcher/audit"
+	"github.com/wanmail/alert-fetcher/deadletter"
+	"github.com/wanmail/alert-fetcher/label"
+	"github.com/wanmail/alert-fetcher/sink"
+)
+
+func TestSinkAudit(t *testing.T) {
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "web-1") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer am.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	log := audit.Open(audit.Config{Path: path, IncludeDocument: true})
+	store, err := deadletter.Open(filepath.Join(dir, "deadletter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scfg := testSink(strings.TrimPrefix(am.URL, "http://"))
+	push := func(s *sinkRunner, id string, host string) {
+		doc := map[string]interface{}{"id": id}
+		if _, err := s.queue.Push(context.Background(), label.Message{ID: id, Labels: map[string]string{"host": host}}, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	withDeadLetters, err := newSinkRunner("am", scfg, Stores{DeadLetters: store, Audit: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := withDeadLetters.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	push(withDeadLetters, "sent", "web-1")
+	push(withDeadLetters, "dead-lettered", "web-2")
+	withDeadLetters.stop()
+
+	withoutDeadLetters, err := newSinkRunner("am", scfg, Stores{Audit: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := withoutDeadLetters.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	push(withoutDeadLetters, "failed", "web-2")
+	withoutDeadLetters.stop()
+
+	// without workers the second message finds the queue full
+	full := scfg
+	full.Queue = sink.QueueConfig{Size: 1, Overflow: sink.OverflowDropNewest}
+	dropping, err := newSinkRunner("am", full, Stores{Audit: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dropping.open(); err != nil {
+		t.Fatal(err)
+	}
+	push(dropping, "queued", "web-1")
+	push(dropping, "dropped", "web-1")
+	dropping.discard()
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := queryAudit(path, audit.Filter{}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]audit.Record)
+	dec := json.NewDecoder(strings.NewReader(out.String()))
+	for dec.More() {
+		var r audit.Record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got[r.Job] = r
+	}
+
+	for _, outcome := range []string{audit.OutcomeSent, audit.OutcomeDeadLettered, audit.OutcomeFailed, audit.OutcomeDropped} {
+		r, ok := got[outcome]
+		if !ok {
+			t.Errorf("No record for %s", outcome)
+			continue
+		}
+		if r.Outcome != outcome {
+			t.Errorf("Unexpected result. Got: %v, Want: %v", r.Outcome, outcome)
+		}
+		if want := map[string]interface{}{"id": outcome}; !reflect.DeepEqual(r.Document, want) {
+			t.Errorf("Unexpected document. Got: %v, Want: %v", r.Document, want)
+		}
+	}
+	if _, ok := got["queued"]; ok {
+		t.Errorf("Unexpected record for a message still queued")
+	}
+}
+
+func TestQueryAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log := audit.Open(audit.Config{Path: path})
+	for _, r := range []audit.Record{
+		{Job: "job1", Sink: "am", Outcome: audit.OutcomeSent, Labels: map[string]string{"host": "web-1"}},
+		{Job: "job2", Sink: "am", Outcome: audit.OutcomeFailed, Labels: map[string]string{"host": "web-2"}},
+	} {
+		if err := log.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := queryAudit(path, audit.Filter{Labels: labelFlags{"host": "web-2"}}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"job":"job2"`) || !strings.Contains(lines[0], `"outcome":"failed"`) {
+		t.Errorf("Unexpected result. Got: %v", out.String())
+	}
+
+	flags := labelFlags{}
+	if err := flags.Set("host"); err == nil {
+		t.Errorf("Expected an error for a label without a value")
+	}
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/deadletter"
+	"github.com/wanmail/alert-fetcher/sink"
+)
+
+const deadLetterUsage = `usage: alert-fetcher deadletter <command> [-config path] [args]
+
+commands:
+  list            list dead-lettered messages
+  show <id>...    print entries as JSON
+  replay [id...]  send entries again through their sink, all if no id is given
+`
+
+// DeadLetter lists, shows and replays the messages in the dead-letter store
+// of a config.
+func DeadLetter(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, deadLetterUsage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("deadletter "+args[0], flag.ExitOnError)
+	path := fs.String("config", "config.json", "config file or directory path")
+	fs.Parse(args[1:])
+
+	cfg, err := config.LoadConfig(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.DeadLetterPath == "" {
+		fmt.Fprintln(os.Stderr, "deadLetterPath is not set in the config")
+		os.Exit(1)
+	}
+
+	store, err := deadletter.Open(cfg.DeadLetterPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		err = listDeadLetters(store, os.Stdout)
+	case "show":
+		err = showDeadLetters(store, fs.Args(), os.Stdout)
+	case "replay":
+		err = replayDeadLetters(cfg, store, fs.Args(), os.Stdout)
+	default:
+		fmt.Fprint(os.Stderr, deadLetterUsage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func listDeadLetters(store *deadletter.Store, w io.Writer) error {
+	entries, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tSINK\tATTEMPTS\tERROR")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", e.ID, e.Time.Format(time.RFC3339), e.Sink, e.Attempts, e.Error)
+	}
+	return tw.Flush()
+}
+
+func showDeadLetters(store *deadletter.Store, ids []string, w io.Writer) error {
+	if len(ids) == 0 {
+		return errors.New("show needs at least one id")
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	for _, id := range ids {
+		e, err := store.Get(id)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayDeadLetters sends the entries with ids, or every entry, through the
+// sink they failed on, using that sink's retry policy. Entries are removed
+// once sent; failures are reported and kept.
+func replayDeadLetters(cfg config.AppConfig, store *deadletter.Store, ids []string, w io.Writer) error {
+	var entries []deadletter.Entry
+	if len(ids) == 0 {
+		all, err := store.List()
+		if err != nil {
+			return err
+		}
+		entries = all
+	} else {
+		for _, id := range ids {
+			e, err := store.Get(id)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	sinks := make(map[string]sink.Sink)
+	failed := 0
+	for _, e := range entries {
+		scfg, ok := cfg.Sink[e.Sink]
+		if !ok {
+			fmt.Fprintf(w, "%s: sink %s not found\n", e.ID, e.Sink)
+			failed++
+			continue
+		}
+
+		s, ok := sinks[e.Sink]
+		if !ok {
+			var err error
+			if s, err = sink.New(scfg); err != nil {
+				return errors.Wrapf(err, "sink %s", e.Sink)
+			}
+			sinks[e.Sink] = s
+		}
+
+		if _, err := sink.SendWithRetry(context.Background(), s, e.Message, scfg.Retry); err != nil {
+			fmt.Fprintf(w, "%s: %v\n", e.ID, err)
+			failed++
+			continue
+		}
+		if err := store.Delete(e.ID); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: replayed\n", e.ID)
+	}
+
+	if failed > 0 {
+		return errors.Errorf("%d of %d entries failed to replay", failed, len(entries))
+	}
+	return nil
+}
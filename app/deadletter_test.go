@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/deadletter"
+	"github.com/wanmail/alert-fetcher/label"
+	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/metrics"
+	"github.com/wanmail/alert-fetcher/sink"
+)
+
+func TestDeadLetterReplay(t *testing.T) {
+	healthy := false
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if !healthy {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer am.Close()
+
+	store, err := deadletter.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scfg := testSink(strings.TrimPrefix(am.URL, "http://"))
+	s, err := newSinkRunner("am", scfg, Stores{DeadLetters: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.queue.Push(context.Background(), label.Message{ID: "1", Labels: map[string]string{"host": "web-1"}}, nil)
+	s.stop()
+
+	entries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Sink != "am" || entries[0].Attempts != 1 || entries[0].Message.Labels["host"] != "web-1" {
+		t.Fatalf("Unexpected result. Got: %+v, Want: one entry for sink am", entries)
+	}
+
+	healthy = true
+	cfg := config.AppConfig{Sink: map[string]sink.SinkConfig{"am": scfg}}
+	if err := replayDeadLetters(cfg, store, nil, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ = store.List()
+	if len(entries) != 0 {
+		t.Errorf("Unexpected result. Got: %d entries after replay, Want: 0", len(entries))
+	}
+}
+
+func TestSinkLost(t *testing.T) {
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer am.Close()
+
+	s, err := newSinkRunner("lost", testSink(strings.TrimPrefix(am.URL, "http://")), Stores{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.queue.Push(context.Background(), label.Message{ID: "1"}, nil)
+	s.stop()
+
+	if !metrics.Lost.DeleteLabelValues("lost") {
+		t.Errorf("Lost message was not counted")
+	}
+}
+
+func TestDeadLetterRedacted(t *testing.T) {
+	// connection errors name the sink's address, which may carry credentials
+	down := httptest.NewServer(http.NotFoundHandler())
+	host := strings.TrimPrefix(down.URL, "http://")
+	down.Close()
+
+	defer logger.SetSecrets(nil)
+	logger.SetSecrets([]string{host})
+
+	store, err := deadletter.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scfg := testSink(host)
+	scfg.Retry.MaxAttempts = 1
+	s, err := newSinkRunner("am", scfg, Stores{DeadLetters: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.queue.Push(context.Background(), label.Message{ID: "1"}, nil)
+	s.stop()
+
+	entries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Error == "" || strings.Contains(entries[0].Error, host) {
+		t.Errorf("Unexpected result. Got: %+v, Want: one entry without %s", entries, host)
+	}
+}
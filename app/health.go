@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/sink"
+	"github.com/wanmail/alert-fetcher/source"
+)
+
+const (
+	// checkTimeout bounds each connectivity check of /readyz.
+	checkTimeout = 5 * time.Second
+
+	// tickGrace is how late a job may run past its schedule before /healthz
+	// considers its scheduler stuck, or /readyz its ticks lagging.
+	tickGrace = time.Minute
+)
+
+// health is the body of /healthz and /readyz: "ok" or the problem for every
+// job, source and sink checked.
+type health struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (h health) write(w http.ResponseWriter) {
+	code := http.StatusOK
+	h.Status = "ok"
+	for _, v := range h.Checks {
+		if v != "ok" {
+			code = http.StatusServiceUnavailable
+			h.Status = "unavailable"
+			break
+		}
+	}
+
+	writeJSON(w, code, h)
+}
+
+// handleHealth reports whether the process is alive: the scheduler of
+// every job must have beaten on schedule, give or take one interval and
+// tickGrace. Ticks held up by a slow source or sink don't count, as
+// restarting the process wouldn't help them; /readyz reports those.
+func (a *App) handleHealth(w http.ResponseWriter, r *http.Request) {
+	st := a.state()
+	h := health{Checks: make(map[string]string, len(st.jobs))}
+	now := time.Now()
+	for name, job := range st.jobs {
+		heartbeat, _ := job.beats()
+		if late(job, heartbeat, now) {
+			h.Checks["job:"+name] = "scheduler stuck since " + heartbeat.Format(time.RFC3339)
+			continue
+		}
+		h.Checks["job:"+name] = "ok"
+	}
+
+	h.write(w)
+}
+
+// late reports whether more than an interval and tickGrace of job passed
+// between t and now.
+func late(job *Job, t time.Time, now time.Time) bool {
+	grace := time.Duration(job.config.Duration)*time.Second + tickGrace
+	return !t.IsZero() && now.Sub(t) > grace
+}
+
+// handleReady reports whether every configured source and sink is running
+// and passes its connectivity check, and whether every job is ticking on
+// schedule.
+func (a *App) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]func(context.Context) error)
+	h := health{Checks: make(map[string]string)}
+
+	st := a.state()
+	if !st.applied {
+		h.Checks["config"] = "not loaded"
+		h.write(w)
+		return
+	}
+	for _, jobcfg := range st.config.Job {
+		job, ok := st.jobs[jobcfg.Name]
+		if !ok {
+			h.Checks["source:"+jobcfg.Name] = "not running"
+			continue
+		}
+		checks["source:"+jobcfg.Name] = checkSource(job.source)
+
+		h.Checks["job:"+jobcfg.Name] = "ok"
+		if _, ticked := job.beats(); !job.Paused() && late(job, ticked, time.Now()) {
+			h.Checks["job:"+jobcfg.Name] = "no tick finished since " + ticked.Format(time.RFC3339)
+		}
+	}
+	for name := range st.config.Sink {
+		s, ok := st.sinks[name]
+		if !ok {
+			h.Checks["sink:"+name] = "not running"
+			continue
+		}
+		checks["sink:"+name] = checkSink(s.checker)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+
+			result := "ok"
+			if err := check(ctx); err != nil {
+				result = logger.Redact(err.Error())
+			}
+
+			mu.Lock()
+			h.Checks[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	h.write(w)
+}
+
+// checkSource returns the check of src, or one that always passes if src
+// cannot check itself.
+func checkSource(src source.Source) func(context.Context) error {
+	if c, ok := src.(source.Checker); ok {
+		return c.Check
+	}
+	return func(context.Context) error { return nil }
+}
+
+// checkSink is checkSource for sinks.
+func checkSink(c sink.Checker) func(context.Context) error {
+	if c == nil {
+		return func(context.Context) error { return nil }
+	}
+	return c.Check
+}
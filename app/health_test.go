@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wanmail/alert-fetcher/checkpoint"
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/sink"
+)
+
+func TestHealthProbes(t *testing.T) {
+	es, am, _ := fakeServers(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	job := testJob("job1", "am")
+	job.SourceConfig.SourceConfig = json.RawMessage(`{"address":"` + es.URL + `","index":"logs","query":"{}","max":10}`)
+
+	checkpoints, _ := checkpoint.Open("")
+	a := NewApp(context.Background(), Stores{Checkpoints: checkpoints})
+	defer a.Shutdown(time.Second)
+
+	srv := httptest.NewServer(a.Handler())
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		name  string
+		sinks map[string]sink.SinkConfig
+		path  string
+		code  int
+		want  string
+	}{
+		{
+			name: "Not ready before the config is applied",
+			path: "/readyz",
+			code: http.StatusServiceUnavailable,
+			want: `"config": "not loaded"`,
+		},
+		{
+			name:  "Ready",
+			sinks: map[string]sink.SinkConfig{"am": testSink(strings.TrimPrefix(am.URL, "http://"))},
+			path:  "/readyz",
+			code:  http.StatusOK,
+			want:  `"sink:am": "ok"`,
+		},
+		{
+			name:  "Alive",
+			sinks: map[string]sink.SinkConfig{"am": testSink(strings.TrimPrefix(am.URL, "http://"))},
+			path:  "/healthz",
+			code:  http.StatusOK,
+			want:  `"job:job1": "ok"`,
+		},
+		{
+			name:  "Sink down",
+			sinks: map[string]sink.SinkConfig{"am": testSink(strings.TrimPrefix(down.URL, "http://"))},
+			path:  "/readyz",
+			code:  http.StatusServiceUnavailable,
+			want:  `"source:job1": "ok"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.sinks != nil {
+				if err := a.Apply(config.AppConfig{Sink: test.sinks, Job: []config.JobConfig{job}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			code, body := get(test.path)
+			if code != test.code || !strings.Contains(body, test.want) {
+				t.Errorf("Unexpected result. Got: %d %s, Want: %d %s", code, body, test.code, test.want)
+			}
+		})
+	}
+
+	// a tick held up by a slow source or sink makes the app unready, while
+	// only a stuck scheduler makes it dead
+	job1 := a.state().jobs["job1"]
+	set := func(field *time.Time, t time.Time) {
+		job1.mu.Lock()
+		defer job1.mu.Unlock()
+		*field = t
+	}
+	set(&job1.ticked, time.Now().Add(-2*time.Hour))
+	if code, body := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Unexpected result. Got: %d %s, Want: %d", code, body, http.StatusOK)
+	}
+	if code, body := get("/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, `"job:job1": "no tick finished since`) {
+		t.Errorf("Unexpected result. Got: %d %s, Want: %d with the job lagging", code, body, http.StatusServiceUnavailable)
+	}
+	set(&job1.heartbeat, time.Now().Add(-2*time.Hour))
+	if code, body := get("/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, `"job:job1": "scheduler stuck since`) {
+		t.Errorf("Unexpected result. Got: %d %s, Want: %d with the scheduler stuck", code, body, http.StatusServiceUnavailable)
+	}
+	set(&job1.heartbeat, time.Now())
+
+	// probes and metrics don't wait for a reload or shutdown in progress
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if code, body := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Unexpected result. Got: %d %s, Want: %d", code, body, http.StatusOK)
+	}
+	ch := make(chan prometheus.Metric, 10)
+	a.Collector().Collect(ch)
+	if got := len(ch); got != 2 {
+		t.Errorf("Unexpected result. Got: %v, Want: %v", got, 2)
+	}
+}
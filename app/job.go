@@ -3,109 +3,434 @@ package app
 import (
 	"context"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/checkpoint"
 	"github.com/wanmail/alert-fetcher/config"
 	"github.com/wanmail/alert-fetcher/label"
+	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/metrics"
 	"github.com/wanmail/alert-fetcher/source"
+	"github.com/wanmail/alert-fetcher/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Job struct {
 	config    config.JobConfig
 	extractor *label.FieldExtractor
+	templates *label.Templates
 	source    source.Source
-	sink      map[string]chan<- label.Message
+	sink      map[string]*queue
+
+	mu          sync.Mutex
+	from        time.Time
+	checkpoints *checkpoint.Store
+	paused      bool
+	lastRun     time.Time
+	lastError   error
+	nextRun     time.Time
+	heartbeat   time.Time
+	ticked      time.Time
+
+	trigger chan struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	// running is done once the job is stopped. Ticks outlive it, except
+	// for pushes blocked on a full queue.
+	running context.Context
 }
 
-func NewJob(cfg config.JobConfig) (*Job, error) {
-	return &Job{
-		config: cfg,
-	}, nil
+// JobStatus is the runtime state of a job.
+type JobStatus struct {
+	Name       string     `json:"name"`
+	Paused     bool       `json:"paused"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	NextRun    time.Time  `json:"nextRun"`
+	Checkpoint time.Time  `json:"checkpoint"`
 }
 
-func (j *Job) Start(ctx context.Context) (err error) {
-	j.extractor = label.NewFieldExtractor(j.config.Labels)
+// NewJob builds the job of cfg, parsing its annotation templates on top of
+// the shared template files.
+func NewJob(cfg config.JobConfig, shared map[string]string) (*Job, error) {
+	templates, err := label.NewTemplates(cfg.Annotations, cfg.TemplateMode, shared)
+	if err != nil {
+		return nil, err
+	}
 
-	j.source, err = source.New(j.config.SourceConfig)
+	src, err := source.New(cfg.SourceConfig)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	extractor := label.NewFieldExtractor(cfg.Labels)
+	if cfg.LabelSeparator != "" {
+		extractor.Separator = cfg.LabelSeparator
 	}
+	extractor.Flattened = cfg.FieldLookup == label.LookupFlattened
 
-	j.sink = make(map[string]chan<- label.Message)
+	j := &Job{
+		config:    cfg,
+		extractor: extractor,
+		templates: templates,
+		source:    src,
+		trigger:   make(chan struct{}, 1),
+	}
+	if err := extractor.SetExprs(cfg.LabelExprs); err != nil {
+		j.Close()
+		return nil, err
+	}
+	if err := extractor.SetFormats(cfg.LabelFormats); err != nil {
+		j.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Start binds the job to its sinks and runs it every Duration seconds until
+// ctx is cancelled or Stop is called.
+func (j *Job) Start(ctx context.Context, sinks map[string]*queue) (err error) {
+	j.sink = make(map[string]*queue)
 	for _, sinkName := range j.config.Sink {
-		sink, ok := sinkMaps[sinkName]
+		sink, ok := sinks[sinkName]
 		if !ok {
-			slog.Error("sink not found", "name", sinkName)
-			continue
+			return errors.Errorf("sink %s not found", sinkName)
 		}
 		j.sink[sinkName] = sink
 	}
 
-	go func() {
-		from := time.Now()
+	j.mu.Lock()
+	j.ticked = time.Now()
+	if j.from.IsZero() {
+		j.from = time.Now()
+		if t, ok := j.checkpoints.Get(j.config.Name); ok {
+			j.from = t
+		}
+	}
+	j.mu.Unlock()
+
+	ctx, j.cancel = context.WithCancel(ctx)
+	j.running = ctx
+	j.done = make(chan struct{})
 
-		timer := time.NewTicker(time.Second * time.Duration(j.config.Duration))
+	go j.run(ctx)
+
+	return nil
+}
 
+func (j *Job) run(ctx context.Context) {
+	defer close(j.done)
+
+	// ticks run apart from the scheduler, so that a tick blocked on a slow
+	// source or a full queue doesn't stop its heartbeat
+	due := make(chan struct{}, 1)
+	ticking := make(chan struct{})
+	go func() {
+		defer close(ticking)
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-timer.C:
-				now := time.Now()
-				err := j.Fetch(ctx, from, now)
-				if err != nil {
-					slog.Warn("job fetch failed", "name", j.config.Name, "err", err)
-				}
-				from = now
+			case <-due:
+				j.tick(ctx)
 			}
 		}
 	}()
+	defer func() { <-ticking }()
 
-	return nil
+	schedule := func() {
+		select {
+		case due <- struct{}{}:
+		default:
+			// a tick is already pending
+		}
+	}
+
+	interval := time.Second * time.Duration(j.config.Duration)
+	timer := time.NewTicker(interval)
+	defer timer.Stop()
+
+	j.beat(time.Now(), interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-timer.C:
+			j.beat(now, interval)
+			// a paused job keeps its checkpoint, so the first window after
+			// it resumes covers the pause
+			if j.Paused() {
+				continue
+			}
+			schedule()
+		case <-j.trigger:
+			schedule()
+		}
+	}
+}
+
+// tick fetches the window from the checkpoint to now. A tick that has
+// started always runs to completion, so stopping the job never leaves half
+// of a window sent.
+func (j *Job) tick(ctx context.Context) {
+	now := time.Now()
+	from := j.Checkpoint()
+
+	ctx, span := tracing.Tracer().Start(j.logContext(context.WithoutCancel(ctx)), "job.tick", trace.WithAttributes(
+		attribute.String("job", j.config.Name),
+		attribute.String("job.type", j.config.Type),
+		attribute.String("window.from", from.Format(time.RFC3339)),
+		attribute.String("window.to", now.Format(time.RFC3339)),
+	))
+	err := j.Fetch(ctx, from, now)
+	tracing.End(span, err)
+
+	metrics.FetchDuration.WithLabelValues(j.config.Name).Observe(time.Since(now).Seconds())
+	if err != nil {
+		metrics.FetchErrors.WithLabelValues(j.config.Name).Inc()
+		slog.WarnContext(ctx, "job fetch failed", "err", err)
+	} else {
+		metrics.LastSuccess.WithLabelValues(j.config.Name).SetToCurrentTime()
+	}
+
+	j.mu.Lock()
+	j.lastRun, j.lastError, j.ticked = now, err, time.Now()
+	j.mu.Unlock()
+
+	// a window that failed, whether at the source or before all of its
+	// messages reached the outbox, is fetched again on the next tick
+	if err != nil {
+		return
+	}
+	j.setCheckpoint(now)
+	if err := j.checkpoints.Flush(); err != nil {
+		slog.WarnContext(ctx, "failed to save checkpoint", "err", err)
+	}
+}
+
+// Trigger runs the job as soon as its current tick, if any, is done. It
+// runs even if the job is paused.
+func (j *Job) Trigger() {
+	select {
+	case j.trigger <- struct{}{}:
+	default:
+		// a run is already pending
+	}
+}
+
+// logContext returns ctx with the job's name on every log record and its
+// log level, if it overrides the global one.
+func (j *Job) logContext(ctx context.Context) context.Context {
+	ctx = logger.With(ctx, slog.String("job", j.config.Name))
+	if j.config.LogLevel != "" {
+		if l, err := logger.ParseLevel(j.config.LogLevel); err == nil {
+			ctx = logger.WithLevel(ctx, l)
+		}
+	}
+	return ctx
+}
+
+func (j *Job) Paused() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.paused
+}
+
+// SetPaused pauses or resumes the scheduled runs of the job.
+func (j *Job) SetPaused(paused bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.paused = paused
+}
+
+// beat records that the scheduler is alive at now and schedules the next
+// run an interval later.
+func (j *Job) beat(now time.Time, interval time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.heartbeat = now
+	j.nextRun = now.Add(interval)
+}
+
+// beats returns the last heartbeat of the scheduler and when the last tick
+// finished, or the job started if it hasn't ticked yet.
+func (j *Job) beats() (heartbeat time.Time, ticked time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.heartbeat, j.ticked
+}
+
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	st := JobStatus{
+		Name:       j.config.Name,
+		Paused:     j.paused,
+		NextRun:    j.nextRun,
+		Checkpoint: j.from,
+	}
+	if !j.lastRun.IsZero() {
+		t := j.lastRun
+		st.LastRun = &t
+	}
+	if j.lastError != nil {
+		st.LastError = logger.Redact(j.lastError.Error())
+	}
+	return st
+}
+
+// Stop cancels the job and waits for its current tick to finish.
+func (j *Job) Stop() {
+	if j.cancel == nil {
+		return
+	}
+
+	j.cancel()
+	<-j.done
+}
+
+// Close releases the source of a job that won't be started again.
+func (j *Job) Close() {
+	if c, ok := j.source.(source.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Warn("failed to close source", "job", j.config.Name, "error", err)
+		}
+	}
+}
+
+// Checkpoint returns the start of the next window the job will fetch.
+func (j *Job) Checkpoint() time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.from
+}
+
+func (j *Job) setCheckpoint(t time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.from = t
+	j.checkpoints.Set(j.config.Name, t)
+}
+
+// ExtractMessage builds the message for data, fetched over window.
+func (j *Job) ExtractMessage(ctx context.Context, data map[string]interface{}, window label.Window) (label.Message, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "extract", trace.WithAttributes(attribute.String("job", j.config.Name)))
+
+	values, err := j.extractor.Extract(data)
+	if err != nil {
+		metrics.ExpressionErrors.WithLabelValues(j.config.Name).Add(float64(countErrors(err)))
+		slog.ErrorContext(ctx, "failed to evaluate label expressions", "error", err)
+		span.RecordError(err)
+	}
+	if missing := label.Missing(values); len(missing) > 0 {
+		metrics.ExtractionErrors.WithLabelValues(j.config.Name).Add(float64(len(missing)))
+		span.SetAttributes(attribute.StringSlice("labels.missing", missing))
+	}
+
+	msg, err := j.buildMessage(values, data, window)
+	if err != nil {
+		metrics.TemplateErrors.WithLabelValues(j.config.Name).Add(float64(countErrors(err)))
+		slog.ErrorContext(ctx, "failed to build annotations", "error", err)
+	}
+	msg.Annotations = tracing.Annotate(ctx, msg.Annotations)
+	// template errors do not stop the alert, but they show on the span
+	tracing.End(span, err)
+
+	return msg, nil
 }
 
-func (j *Job) ExtractMessage(ctx context.Context, data map[string]interface{}) (label.Message, error) {
-	labels := j.extractor.ExtractString(data)
+// buildMessage returns the message for the label values extracted from
+// data along with any annotation template errors. The message is usable
+// even when an error is returned.
+func (j *Job) buildMessage(values map[string]interface{}, data map[string]interface{}, window label.Window) (label.Message, error) {
+	labels := j.extractor.Render(values)
 	for k, v := range j.config.StaticLabels {
 		if _, ok := labels[k]; !ok {
 			labels[k] = v
 		}
 	}
 
-	annotations := label.BuildAnnotations(labels, j.config.Annotations)
+	annotations, err := j.templates.Execute(label.TemplateData(labels, j.extractor.Names(), data, j.config.Name, window))
 
 	return label.Message{
 		ID:          j.config.Name,
 		Labels:      labels,
 		Annotations: annotations,
-	}, nil
+	}, err
 }
 
-func (j *Job) Send(ctx context.Context, msg label.Message) error {
+// countErrors returns how many errors a joined error holds.
+func countErrors(err error) int {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return len(joined.Unwrap())
+	}
+	return 1
+}
+
+// Send queues msg, built from doc, for every sink of the job. A push
+// blocked on a full queue gives up once the job is stopped.
+func (j *Job) Send(ctx context.Context, msg label.Message, doc map[string]interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if j.running != nil {
+		defer context.AfterFunc(j.running, cancel)()
+	}
+
 	for name, sink := range j.sink {
-		sink <- msg
+		ctx := logger.With(ctx, slog.String("sink", name))
 
-		slog.InfoContext(ctx, "send data success", "name", j.config.Name, "sink", name)
+		res, err := sink.Push(ctx, msg, doc)
+		if err != nil {
+			return errors.Wrapf(err, "sink %s", name)
+		}
+		switch res {
+		case pushDroppedNewest:
+			metrics.Dropped.WithLabelValues(name).Inc()
+			slog.WarnContext(ctx, "sink queue full, message dropped")
+			continue
+		case pushDroppedOldest:
+			metrics.Dropped.WithLabelValues(name).Inc()
+			slog.WarnContext(ctx, "sink queue full, oldest queued message dropped")
+		}
+
+		slog.InfoContext(ctx, "send data success")
 	}
 
 	return nil
 }
 
 func (j *Job) FetchBatch(ctx context.Context, from time.Time, now time.Time) (err error) {
-	data, err := j.source.FetchOne(ctx, from, now)
+	qctx, span := j.startQuery(ctx)
+	data, err := j.source.FetchOne(qctx, from, now)
+	tracing.End(span, err)
 	if err != nil {
 		return
 	}
-	slog.InfoContext(ctx, "fetch batch data success", "name", j.config.Name)
+	slog.InfoContext(ctx, "fetch batch data success")
+	if data != nil {
+		metrics.Hits.WithLabelValues(j.config.Name).Inc()
+	}
 
-	message, err := j.ExtractMessage(ctx, data)
+	message, err := j.ExtractMessage(ctx, data, label.Window{From: from, Now: now})
 	if err != nil {
 		return
 	}
-	slog.InfoContext(ctx, "extract data success", "name", j.config.Name)
+	slog.InfoContext(ctx, "extract data success")
 
-	err = j.Send(ctx, message)
+	err = j.Send(ctx, message, data)
 	if err != nil {
 		return
 	}
@@ -114,20 +439,24 @@ func (j *Job) FetchBatch(ctx context.Context, from time.Time, now time.Time) (er
 }
 
 func (j *Job) FetchStream(ctx context.Context, from time.Time, now time.Time) (err error) {
-	data, err := j.source.FetchAll(ctx, from, now)
+	qctx, span := j.startQuery(ctx)
+	data, err := j.source.FetchAll(qctx, from, now)
+	span.SetAttributes(attribute.Int("hits", len(data)))
+	tracing.End(span, err)
 	if err != nil {
 		return
 	}
-	slog.InfoContext(ctx, "fetch stream data success", "name", j.config.Name, "count", len(data))
+	slog.InfoContext(ctx, "fetch stream data success", "count", len(data))
+	metrics.Hits.WithLabelValues(j.config.Name).Add(float64(len(data)))
 
 	for _, d := range data {
-		message, err := j.ExtractMessage(ctx, d)
+		message, err := j.ExtractMessage(ctx, d, label.Window{From: from, Now: now})
 		if err != nil {
 			return err
 		}
-		slog.InfoContext(ctx, "extract data success", "name", j.config.Name)
+		slog.InfoContext(ctx, "extract data success")
 
-		err = j.Send(ctx, message)
+		err = j.Send(ctx, message, d)
 		if err != nil {
 			return err
 		}
@@ -136,6 +465,13 @@ func (j *Job) FetchStream(ctx context.Context, from time.Time, now time.Time) (e
 	return nil
 }
 
+func (j *Job) startQuery(ctx context.Context) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "source.query", trace.WithAttributes(
+		attribute.String("job", j.config.Name),
+		attribute.String("source.type", j.config.SourceConfig.SourceType),
+	))
+}
+
 func (j *Job) Fetch(ctx context.Context, from time.Time, now time.Time) (err error) {
 	switch j.config.Type {
 	case "batch":
@@ -8,38 +8,89 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/wanmail/alert-fetcher/audit"
+	"github.com/wanmail/alert-fetcher/checkpoint"
 	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/deadletter"
 	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/metrics"
+	"github.com/wanmail/alert-fetcher/tracing"
 )
 
-var configPath string
+var (
+	configPath string
+	watch      bool
+)
 
 func init() {
-	flag.StringVar(&configPath, "config", "config.json", "config file path")
+	flag.StringVar(&configPath, "config", "config.json", "config file or directory path")
+	flag.BoolVar(&watch, "watch", false, "reload the config when its files change")
 }
 
 func Start() {
-	cfg := config.LoadConfig(configPath)
+	flag.Parse()
 
-	logger.InitLogger(cfg.Log)
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	logger.SetSecrets(cfg.Secrets())
+	if err := logger.InitLogger(cfg.Log); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
 	slog.Info("config load success")
 
-	InitSink(cfg.Sink)
+	stopTracing, err := tracing.Init(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("failed to init tracing", err)
+	}
 
-	for _, jobcfg := range cfg.Job {
-		job, err := NewJob(jobcfg)
-		if err != nil {
-			panic(err)
-		}
+	checkpoints, err := checkpoint.Open(cfg.CheckpointPath)
+	if err != nil {
+		fatal("failed to open checkpoints", err)
+	}
 
-		err = job.Start(context.Background())
-		if err != nil {
-			panic(err)
+	stores := Stores{Checkpoints: checkpoints, OutboxDir: cfg.OutboxPath}
+	if cfg.DeadLetterPath != "" {
+		if stores.DeadLetters, err = deadletter.Open(cfg.DeadLetterPath); err != nil {
+			fatal("failed to open dead letters", err)
 		}
+	}
 
-		slog.Info("job start success", "name", jobcfg.Name)
+	if cfg.Audit.Path != "" {
+		stores.Audit = audit.Open(cfg.Audit)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := NewApp(ctx, stores)
+	if err := a.Apply(cfg); err != nil {
+		fatal("failed to start", err)
+	}
+	reloaded(true)
+
+	metrics.Registry.MustRegister(a.Collector())
+	if cfg.Server.Listen != "" {
+		go func() {
+			if err := a.Serve(ctx, cfg.Server.Listen); err != nil {
+				slog.Error("http server failed", "error", err)
+			}
+		}()
+	}
+
+	if watch {
+		go func() {
+			if err := a.Watch(configPath); err != nil {
+				slog.Error("config watch failed", "error", err)
+			}
+		}()
 	}
 
 	c := make(chan os.Signal, 1)
@@ -47,10 +98,89 @@ func Start() {
 
 	for s := range c {
 		switch s {
-		case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM:
+		case syscall.SIGHUP:
+			a.Reload(configPath)
+		case syscall.SIGINT, syscall.SIGTERM:
+			slog.Info("shutting down", "signal", s.String())
+
+			// Shutdown stops the jobs first; ctx is only cancelled once the
+			// sinks had their chance to drain, as it also stops their retries
+			timeout := time.Second * time.Duration(a.state().config.ShutdownTimeout)
+
+			err := a.Shutdown(timeout)
+			cancel()
+			if stores.Audit != nil {
+				if err := stores.Audit.Close(); err != nil {
+					slog.Error("failed to close audit log", "error", err)
+				}
+			}
+
+			// flush the spans of the last ticks
+			tctx, tcancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := stopTracing(tctx); err != nil {
+				slog.Error("failed to flush traces", "error", err)
+			}
+			tcancel()
+
+			if err != nil {
+				slog.Error("shutdown failed", "error", err)
+				os.Exit(1)
+			}
+			slog.Info("shutdown complete")
 			os.Exit(0)
+		case syscall.SIGUSR1:
+			stepLogLevel(-4)
+		case syscall.SIGUSR2:
+			stepLogLevel(4)
 		default:
 			fmt.Println("signal", s)
 		}
 	}
 }
+
+// fatal logs a startup error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// stepLogLevel makes the logs more verbose by one level for a negative
+// delta and less verbose for a positive one, within debug and error.
+func stepLogLevel(delta slog.Level) {
+	l := logger.Level() + delta
+	if l < slog.LevelDebug {
+		l = slog.LevelDebug
+	}
+	if l > slog.LevelError {
+		l = slog.LevelError
+	}
+
+	logger.SetLevel(l)
+	slog.Warn("log level changed", "level", l.String())
+}
+
+// Validate checks a config file and reports every problem found in it.
+func Validate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := fs.String("config", "config.json", "config file or directory path")
+	dump := fs.Bool("dump", false, "print the effective config with secrets redacted")
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *dump {
+		out, err := cfg.Dump()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Println("config ok")
+}
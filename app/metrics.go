@@ -0,0 +1,48 @@
+package app
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	windowLagDesc = prometheus.NewDesc(
+		"alert_fetcher_job_window_lag_seconds",
+		"Time since the start of the next window of a job.",
+		[]string{"job"}, nil,
+	)
+	queueDepthDesc = prometheus.NewDesc(
+		"alert_fetcher_sink_queue_depth",
+		"Messages waiting in the queue of a sink, including spilled ones.",
+		[]string{"sink"}, nil,
+	)
+)
+
+// collector reports the state of the running jobs and sinks at scrape time.
+type collector struct {
+	a *App
+}
+
+// Collector returns a prometheus collector for the jobs and sinks a is
+// running.
+func (a *App) Collector() prometheus.Collector {
+	return collector{a: a}
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- windowLagDesc
+	ch <- queueDepthDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	st := c.a.state()
+
+	now := time.Now()
+	for name, job := range st.jobs {
+		ch <- prometheus.MustNewConstMetric(windowLagDesc, prometheus.GaugeValue, now.Sub(job.Checkpoint()).Seconds(), name)
+	}
+	for name, s := range st.sinks {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(s.queue.Len()), name)
+	}
+}
@@ -0,0 +1,226 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/label"
+)
+
+// outboxCompactEvery is how many acks are appended before the log is
+// rewritten with only the unacked messages.
+const outboxCompactEvery = 1000
+
+// outboxError marks a message that could not be made durable. A job must
+// not move its checkpoint past a window that hit one.
+type outboxError struct {
+	err error
+}
+
+func (e *outboxError) Error() string {
+	return "failed to write outbox: " + e.err.Error()
+}
+
+func (e *outboxError) Unwrap() error {
+	return e.err
+}
+
+// outboxRecord is a line of the outbox log: either a message, with its
+// source document when the audit log wants it, or the ack of an earlier
+// one.
+type outboxRecord struct {
+	Seq     uint64                 `json:"seq,omitempty"`
+	Message *label.Message         `json:"message,omitempty"`
+	Doc     map[string]interface{} `json:"doc,omitempty"`
+	Ack     uint64                 `json:"ack,omitempty"`
+}
+
+// outbox is the write-ahead log of a sink. Messages are synced to it before
+// they are queued and acked once the sink is done with them, so whatever is
+// unacked when the process dies is delivered again on the next start.
+type outbox struct {
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	seq     uint64
+	pending map[uint64]outboxRecord
+	acks    int
+}
+
+// openOutbox opens the log at path and returns the messages a previous run
+// left unacked, oldest first.
+func openOutbox(path string) (*outbox, []delivery, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open outbox")
+	}
+
+	o := &outbox{path: path, pending: make(map[uint64]outboxRecord)}
+	if err := o.replay(); err != nil {
+		return nil, nil, err
+	}
+	if err := o.compact(); err != nil {
+		return nil, nil, err
+	}
+
+	left := make([]delivery, 0, len(o.pending))
+	for seq, rec := range o.pending {
+		left = append(left, delivery{Seq: seq, Message: *rec.Message, Doc: rec.Doc})
+	}
+	sort.Slice(left, func(i, j int) bool { return left[i].Seq < left[j].Seq })
+
+	return o, left, nil
+}
+
+// replay reads the log into pending.
+func (o *outbox) replay() error {
+	f, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to open outbox")
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		var rec outboxRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// a crash can leave the last line half written
+			slog.Warn("skipping invalid outbox record", "path", o.path, "error", err)
+			continue
+		}
+		if rec.Seq > o.seq {
+			o.seq = rec.Seq
+		}
+		if rec.Message != nil {
+			o.pending[rec.Seq] = rec
+		}
+		if rec.Ack != 0 {
+			delete(o.pending, rec.Ack)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return errors.Wrap(err, "failed to read outbox")
+	}
+	return nil
+}
+
+// Append writes msg and doc, which may be nil, to the log and syncs them
+// to disk.
+func (o *outbox) Append(msg label.Message, doc map[string]interface{}) (uint64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.seq++
+	rec := outboxRecord{Seq: o.seq, Message: &msg, Doc: doc}
+	if err := o.write(rec); err != nil {
+		return 0, &outboxError{err}
+	}
+	if err := o.f.Sync(); err != nil {
+		return 0, &outboxError{err}
+	}
+	o.pending[o.seq] = rec
+
+	return o.seq, nil
+}
+
+// Ack marks seq as done. Acks are not synced: losing one only means the
+// message is delivered again.
+func (o *outbox) Ack(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.pending[seq]; !ok {
+		return
+	}
+	delete(o.pending, seq)
+
+	if err := o.write(outboxRecord{Ack: seq}); err != nil {
+		slog.Error("failed to ack outbox message", "path", o.path, "error", err)
+		return
+	}
+
+	// compacting rewrites the whole log, so it is only done every so many
+	// acks rather than whenever the log is drained
+	o.acks++
+	if o.acks >= outboxCompactEvery {
+		if err := o.compact(); err != nil {
+			slog.Error("failed to compact outbox", "path", o.path, "error", err)
+		}
+	}
+}
+
+func (o *outbox) write(rec outboxRecord) error {
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = o.f.Write(append(raw, '\n'))
+	return err
+}
+
+// compact replaces the log with one holding only the pending messages.
+func (o *outbox) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to compact outbox")
+	}
+	defer os.Remove(tmp.Name())
+
+	seqs := make([]uint64, 0, len(o.pending))
+	for seq := range o.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	w := bufio.NewWriter(tmp)
+	for _, seq := range seqs {
+		raw, err := json.Marshal(o.pending[seq])
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(raw, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to compact outbox")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to compact outbox")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to compact outbox")
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return errors.Wrap(err, "failed to compact outbox")
+	}
+
+	if o.f != nil {
+		o.f.Close()
+	}
+	o.f, err = os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open outbox")
+	}
+	o.acks = 0
+
+	return nil
+}
+
+func (o *outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.f.Close()
+}
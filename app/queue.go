@@ -0,0 +1,439 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/label"
+	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/sink"
+	"github.com/wanmail/alert-fetcher/tracing"
+)
+
+// delivery is a queued message. Seq is its position in the sink's outbox,
+// or zero without one. Trace is the traceparent of the job tick that
+// produced it, Level the log level of its job if it overrides the global
+// one, and Doc the source document when the audit log wants it.
+type delivery struct {
+	Seq     uint64                 `json:"seq,omitempty"`
+	Trace   string                 `json:"trace,omitempty"`
+	Level   *slog.Level            `json:"level,omitempty"`
+	Message label.Message          `json:"message"`
+	Doc     map[string]interface{} `json:"doc,omitempty"`
+}
+
+// queue is the bounded buffer between the jobs and the workers of a sink.
+// What happens when it is full depends on the overflow policy.
+type queue struct {
+	ch       chan delivery
+	overflow string
+
+	// mu serialises the pushes of drop-oldest, drop-newest and spill so
+	// that they keep their order
+	mu    sync.Mutex
+	spill *spill
+
+	outbox     *outbox
+	left       []delivery
+	started    bool
+	redelivery sync.WaitGroup
+
+	// keepDocs keeps the source documents pushed with messages
+	keepDocs bool
+
+	// drop, if set, is called with every message dropped by the overflow
+	// policy
+	drop func(delivery)
+}
+
+// newQueue opens a queue and starts moving spilled and left over messages
+// into it.
+func newQueue(cfg sink.QueueConfig, outboxPath string) (*queue, error) {
+	q, err := openQueue(cfg, outboxPath)
+	if err != nil {
+		return nil, err
+	}
+	q.start()
+	return q, nil
+}
+
+// openQueue opens the files of a queue without starting it. With an
+// outboxPath, pushed messages are written ahead to it and the ones a
+// previous run left unacked are queued again once it starts.
+func openQueue(cfg sink.QueueConfig, outboxPath string) (*queue, error) {
+	q := &queue{
+		ch:       make(chan delivery, cfg.Size),
+		overflow: cfg.WithDefaults().Overflow,
+	}
+
+	if outboxPath != "" {
+		o, l, err := openOutbox(outboxPath)
+		if err != nil {
+			return nil, err
+		}
+		q.outbox, q.left = o, l
+	}
+
+	if cfg.Overflow == sink.OverflowSpill {
+		s, err := openSpill(cfg.SpillPath)
+		if err != nil {
+			if q.outbox != nil {
+				q.outbox.Close()
+			}
+			return nil, err
+		}
+		if q.outbox != nil {
+			// the outbox delivers everything unacked again, spilled or not
+			s.Clear()
+		}
+		q.spill = s
+	}
+
+	return q, nil
+}
+
+// start moves spilled and left over messages into the channel as it frees
+// up.
+func (q *queue) start() {
+	q.started = true
+	if q.spill != nil {
+		go q.pump()
+	}
+
+	if left := q.left; len(left) > 0 {
+		q.left = nil
+		slog.Info("redelivering outbox messages", "path", q.outbox.path, "count", len(left))
+		q.redelivery.Add(1)
+		go func() {
+			defer q.redelivery.Done()
+			for _, d := range left {
+				q.ch <- d
+			}
+		}()
+	}
+}
+
+// discard releases the files of a queue that was never started.
+func (q *queue) discard() {
+	if q.spill != nil {
+		q.spill.w.Close()
+		q.spill.rf.Close()
+	}
+	q.closeOutbox()
+}
+
+// pushResult tells what Push did to make room for a message.
+type pushResult int
+
+const (
+	// pushQueued means the message was queued without dropping anything.
+	pushQueued pushResult = iota
+	// pushDroppedOldest means the message was queued in place of the oldest
+	// one waiting.
+	pushDroppedOldest
+	// pushDroppedNewest means the message itself was dropped.
+	pushDroppedNewest
+)
+
+// Push queues msg, built from doc, reporting whether msg or an older
+// message was dropped to make room. An error means msg may not have been
+// queued. A push blocked on a full queue gives up once ctx is done.
+func (q *queue) Push(ctx context.Context, msg label.Message, doc map[string]interface{}) (pushResult, error) {
+	d := delivery{Message: msg, Trace: tracing.Inject(ctx)}
+	if l, ok := logger.LevelFrom(ctx); ok {
+		d.Level = &l
+	}
+	if q.keepDocs {
+		d.Doc = doc
+	}
+
+	if q.overflow == sink.OverflowBlock {
+		// blocking pushes don't take mu, so a full queue doesn't hold up
+		// the other policies' bookkeeping, Len or redelivery
+		if err := q.append(&d); err != nil {
+			return pushQueued, err
+		}
+		select {
+		case q.ch <- d:
+			return pushQueued, nil
+		case <-ctx.Done():
+			// the window is fetched again, so the outbox can let go of it
+			q.Ack(d)
+			return pushQueued, errors.Wrap(ctx.Err(), "queue full")
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.append(&d); err != nil {
+		return pushQueued, err
+	}
+
+	switch q.overflow {
+	case sink.OverflowDropNewest:
+		select {
+		case q.ch <- d:
+			return pushQueued, nil
+		default:
+			q.dropped(d)
+			return pushDroppedNewest, nil
+		}
+
+	case sink.OverflowDropOldest:
+		res := pushQueued
+		for {
+			select {
+			case q.ch <- d:
+				return res, nil
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.dropped(old)
+				res = pushDroppedOldest
+			default:
+			}
+		}
+
+	case sink.OverflowSpill:
+		// once messages are on disk, new ones follow them to keep the order
+		if q.spill.Len() == 0 {
+			select {
+			case q.ch <- d:
+				return pushQueued, nil
+			default:
+			}
+		}
+		return pushQueued, q.spill.Append(d)
+
+	default:
+		return pushQueued, errors.Errorf("unknown overflow policy %q", q.overflow)
+	}
+}
+
+// append writes d ahead to the outbox, if there is one.
+func (q *queue) append(d *delivery) (err error) {
+	if q.outbox != nil {
+		d.Seq, err = q.outbox.Append(d.Message, d.Doc)
+	}
+	return err
+}
+
+// Ack tells the outbox the sink is done with d.
+func (q *queue) Ack(d delivery) {
+	if q.outbox != nil && d.Seq != 0 {
+		q.outbox.Ack(d.Seq)
+	}
+}
+
+func (q *queue) dropped(d delivery) {
+	q.Ack(d)
+	if q.drop != nil {
+		q.drop(d)
+	}
+}
+
+// Len returns the number of messages waiting, including spilled ones.
+func (q *queue) Len() int {
+	n := len(q.ch)
+	if q.spill != nil {
+		n += q.spill.Len()
+	}
+	return n
+}
+
+// pump moves spilled messages back into the channel as it frees up.
+func (q *queue) pump() {
+	defer close(q.spill.done)
+
+	for {
+		d, ok, err := q.spill.Next()
+		if err != nil {
+			slog.Error("failed to read spilled message", "error", err)
+			continue
+		}
+		if !ok {
+			return
+		}
+		q.ch <- d
+		q.spill.Done()
+	}
+}
+
+// Close waits for spilled and redelivered messages to be moved into the
+// channel and closes it. Nothing may be pushed afterwards.
+func (q *queue) Close() {
+	if q.spill != nil {
+		q.spill.Close()
+		<-q.spill.done
+	}
+	q.redelivery.Wait()
+	close(q.ch)
+}
+
+// closeOutbox releases the outbox once the workers are done acking.
+func (q *queue) closeOutbox() {
+	if q.outbox == nil {
+		return
+	}
+	if err := q.outbox.Close(); err != nil {
+		slog.Error("failed to close outbox", "error", err)
+	}
+}
+
+// spill is a FIFO of deliveries in a JSON lines file. The file is truncated
+// whenever it has been read to the end. Messages left over from a previous
+// run are delivered again.
+type spill struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	w       *os.File
+	r       *bufio.Reader
+	rf      *os.File
+	pending int
+	closing bool
+	done    chan struct{}
+}
+
+func openSpill(path string) (*spill, error) {
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open spill file")
+	}
+	rf, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		return nil, errors.Wrap(err, "failed to open spill file")
+	}
+
+	// count what a previous run left behind
+	left, err := os.ReadFile(path)
+	if err != nil {
+		w.Close()
+		rf.Close()
+		return nil, errors.Wrap(err, "failed to read spill file")
+	}
+
+	s := &spill{
+		w:       w,
+		rf:      rf,
+		r:       bufio.NewReader(rf),
+		pending: bytes.Count(left, []byte("\n")),
+		done:    make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	return s, nil
+}
+
+func (s *spill) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pending
+}
+
+func (s *spill) Append(d delivery) error {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write(append(raw, '\n')); err != nil {
+		return errors.Wrap(err, "failed to spill message")
+	}
+	s.pending++
+	s.cond.Signal()
+
+	return nil
+}
+
+// Next blocks until a message is spilled and returns it. The message still
+// counts as pending until Done is called, so new pushes keep queueing behind
+// it. It returns false once the spill is closed and empty.
+func (s *spill) Next() (delivery, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.pending == 0 {
+		if s.closing {
+			s.w.Close()
+			s.rf.Close()
+			return delivery{}, false, nil
+		}
+		s.cond.Wait()
+	}
+
+	var d delivery
+	line, err := s.r.ReadBytes('\n')
+	if err != nil {
+		s.release()
+		return d, true, errors.Wrap(err, "failed to read spill file")
+	}
+	if err := json.Unmarshal(line, &d); err != nil {
+		s.release()
+		return d, true, errors.Wrap(err, "invalid spilled message")
+	}
+
+	return d, true, nil
+}
+
+// Done marks the message returned by Next as delivered.
+func (s *spill) Done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.release()
+}
+
+// release drops one pending message, truncating the file once every
+// message in it has been read.
+func (s *spill) release() {
+	s.pending--
+	if s.pending == 0 {
+		s.reset()
+	}
+}
+
+// Clear discards what a previous run left in the file.
+func (s *spill) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending > 0 {
+		s.pending = 0
+		s.reset()
+	}
+}
+
+// reset truncates the drained file so it does not grow forever.
+func (s *spill) reset() {
+	if err := s.w.Truncate(0); err != nil {
+		slog.Error("failed to truncate spill file", "error", err)
+		return
+	}
+	if _, err := s.rf.Seek(0, io.SeekStart); err != nil {
+		slog.Error("failed to rewind spill file", "error", err)
+		return
+	}
+	s.r.Reset(s.rf)
+}
+
+func (s *spill) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closing = true
+	s.cond.Broadcast()
+}
@@ -0,0 +1,278 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wanmail/alert-fetcher/checkpoint"
+	"github.com/wanmail/alert-fetcher/label"
+	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/sink"
+)
+
+// drain closes q while consuming it like a worker would.
+func drain(q *queue) []string {
+	done := make(chan []string)
+	go func() {
+		var ids []string
+		for d := range q.ch {
+			ids = append(ids, d.Message.ID)
+		}
+		done <- ids
+	}()
+
+	q.Close()
+	return <-done
+}
+
+func TestQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow string
+		pushed   []pushResult
+		expected []string
+	}{
+		{
+			name:     "Drop newest",
+			overflow: sink.OverflowDropNewest,
+			pushed:   []pushResult{pushQueued, pushQueued, pushDroppedNewest, pushDroppedNewest},
+			expected: []string{"1", "2"},
+		},
+		{
+			name:     "Drop oldest",
+			overflow: sink.OverflowDropOldest,
+			pushed:   []pushResult{pushQueued, pushQueued, pushDroppedOldest, pushDroppedOldest},
+			expected: []string{"3", "4"},
+		},
+		{
+			name:     "Spill",
+			overflow: sink.OverflowSpill,
+			pushed:   []pushResult{pushQueued, pushQueued, pushQueued, pushQueued},
+			expected: []string{"1", "2", "3", "4"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := newQueue(sink.QueueConfig{
+				Size:      2,
+				Overflow:  test.overflow,
+				SpillPath: filepath.Join(t.TempDir(), "spill"),
+			}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, id := range []string{"1", "2", "3", "4"} {
+				res, err := q.Push(context.Background(), label.Message{ID: id}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res != test.pushed[i] {
+					t.Errorf("Unexpected result for %s. Got: %v, Want: %v", id, res, test.pushed[i])
+				}
+			}
+
+			if got := drain(q); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Unexpected messages. Got: %v, Want: %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestQueueSpillLeftovers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill")
+	cfg := sink.QueueConfig{Size: 1, Overflow: sink.OverflowSpill, SpillPath: path}
+
+	s, err := openSpill(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := s.Append(delivery{Message: label.Message{ID: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.w.Close()
+	s.rf.Close()
+
+	q, err := newQueue(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Push(context.Background(), label.Message{ID: "c"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := drain(q); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Unexpected messages. Got: %v", got)
+	}
+}
+
+func TestQueueOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "am.wal")
+	cfg := sink.QueueConfig{Size: 10}
+
+	q, err := newQueue(cfg, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.keepDocs = true
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := q.Push(context.Background(), label.Message{ID: id}, map[string]interface{}{"id": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only b is sent before the process dies
+	<-q.ch
+	q.Ack(<-q.ch)
+	q.outbox.Close()
+
+	o, left, err := openOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Close()
+	if len(left) != 2 || !reflect.DeepEqual(left[0].Doc, map[string]interface{}{"id": "a"}) {
+		t.Errorf("Unexpected leftovers. Got: %+v", left)
+	}
+
+	q, err = newQueue(cfg, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(q); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Unexpected messages. Got: %v, Want: %v", got, []string{"a", "c"})
+	}
+	q.closeOutbox()
+}
+
+func TestOutboxCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "am.wal")
+	o, _, err := openOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	size := func() int64 {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+
+	for i := 0; i < outboxCompactEvery; i++ {
+		seq, err := o.Append(label.Message{ID: "a"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.Ack(seq)
+
+		// a drained log is only rewritten once enough acks piled up
+		if i < outboxCompactEvery-1 && size() == 0 {
+			t.Fatalf("Outbox compacted after %d acks", i+1)
+		}
+	}
+	if got := size(); got != 0 {
+		t.Errorf("Unexpected result. Got: %v, Want: %v", got, 0)
+	}
+}
+
+func TestQueueLogLevel(t *testing.T) {
+	q, err := newQueue(sink.QueueConfig{Size: 1, Overflow: sink.OverflowSpill, SpillPath: filepath.Join(t.TempDir(), "spill")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second message goes through the spill file
+	ctx := logger.WithLevel(context.Background(), slog.LevelDebug)
+	for _, id := range []string{"a", "b"} {
+		if _, err := q.Push(ctx, label.Message{ID: id}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		d := <-q.ch
+		if d.Level == nil || *d.Level != slog.LevelDebug {
+			t.Errorf("Unexpected level for %s. Got: %v, Want: %v", d.Message.ID, d.Level, slog.LevelDebug)
+		}
+	}
+	q.Close()
+}
+
+func TestQueueBlockCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "am.wal")
+	q, err := newQueue(sink.QueueConfig{Size: 1}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Push(context.Background(), label.Message{ID: "a"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Push(ctx, label.Message{ID: "b"}, nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// the given up message is not redelivered
+	if got := drain(q); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("Unexpected messages. Got: %v, Want: %v", got, []string{"a"})
+	}
+	q.closeOutbox()
+	o, left, err := openOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Close()
+	if len(left) != 1 || left[0].Message.ID != "a" {
+		t.Errorf("Unexpected leftovers. Got: %+v", left)
+	}
+}
+
+func TestJobStopBlocked(t *testing.T) {
+	es, _, _ := fakeServers(t, `{"host":{"name":"a"}}`, `{"host":{"name":"b"}}`)
+	cfg := testJob("job1", "am")
+	cfg.SourceConfig.SourceConfig = json.RawMessage(`{"address":"` + es.URL + `","index":"logs","query":"{}","max":10}`)
+
+	job, err := NewJob(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+	job.checkpoints, _ = checkpoint.Open("")
+
+	// nothing reads the queue, so the second message blocks the tick
+	q, err := newQueue(sink.QueueConfig{Size: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Start(context.Background(), map[string]*queue{"am": q}); err != nil {
+		t.Fatal(err)
+	}
+	job.Trigger()
+	for q.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		job.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Job blocked on a full queue was not stopped")
+	}
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/wanmail/alert-fetcher/metrics"
+)
+
+// Handler returns the routes of the embedded HTTP server.
+func (a *App) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/api/", a.adminHandler())
+	mux.HandleFunc("/healthz", a.handleHealth)
+	mux.HandleFunc("/readyz", a.handleReady)
+
+	return mux
+}
+
+// Serve runs the embedded HTTP server on listen until ctx is cancelled.
+func (a *App) Serve(ctx context.Context, listen string) error {
+	srv := &http.Server{
+		Addr:              listen,
+		Handler:           a.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("http server listening", "listen", listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wanmail/alert-fetcher/checkpoint"
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/metrics"
+	"github.com/wanmail/alert-fetcher/sink"
+)
+
+func TestMetrics(t *testing.T) {
+	es, am, alerts := fakeServers(t, `{"host":{"name":"web-1"}}`)
+
+	job := testJob("metrics", "am")
+	job.Duration = 1
+	job.Labels["missing"] = "no.such.field"
+	job.LabelExprs = map[string]string{"broken": `host + 1`}
+	job.SourceConfig.SourceConfig = json.RawMessage(`{"address":"` + es.URL + `","index":"logs","query":"{}","max":10}`)
+
+	checkpoints, _ := checkpoint.Open("")
+	a := NewApp(context.Background(), Stores{Checkpoints: checkpoints})
+	err := a.Apply(config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am": testSink(strings.TrimPrefix(am.URL, "http://"))},
+		Job:  []config.JobConfig{job},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := a.Collector()
+	metrics.Registry.MustRegister(c)
+	defer metrics.Registry.Unregister(c)
+
+	select {
+	case <-alerts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No alert was sent")
+	}
+
+	srv := httptest.NewServer(a.Handler())
+	defer srv.Close()
+
+	// the alert is received before the sink records it, so wait until the
+	// job has finished its tick too
+	var body string
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get(srv.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		body = string(raw)
+		if strings.Contains(body, `alert_fetcher_sink_sent_total{sink="am"}`) &&
+			strings.Contains(body, `alert_fetcher_job_last_success_timestamp_seconds{job="metrics"}`) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	a.Shutdown(time.Second)
+
+	for _, want := range []string{
+		`alert_fetcher_job_hits_total{job="metrics"}`,
+		`alert_fetcher_job_fetch_duration_seconds_count{job="metrics"}`,
+		`alert_fetcher_job_last_success_timestamp_seconds{job="metrics"}`,
+		`alert_fetcher_job_window_lag_seconds{job="metrics"}`,
+		`alert_fetcher_extraction_errors_total{job="metrics"}`,
+		`alert_fetcher_expression_errors_total{job="metrics"}`,
+		`alert_fetcher_sink_sent_total{sink="am"}`,
+		`alert_fetcher_sink_send_duration_seconds_count{sink="am"}`,
+		`alert_fetcher_sink_queue_depth{sink="am"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Unexpected result. Metric %s is missing", want)
+		}
+	}
+	// a failed label expression is not a template error
+	if want := `alert_fetcher_template_errors_total{job="metrics"}`; strings.Contains(body, want) {
+		t.Errorf("Unexpected result. Metric %s is present", want)
+	}
+}
@@ -1,26 +1,213 @@
 package app
 
 import (
+	"context"
 	"log/slog"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/wanmail/alert-fetcher/audit"
+	"github.com/wanmail/alert-fetcher/deadletter"
 	"github.com/wanmail/alert-fetcher/label"
+	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/metrics"
 	"github.com/wanmail/alert-fetcher/sink"
+	"github.com/wanmail/alert-fetcher/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var sinkMaps = map[string]chan<- label.Message{}
+// sinkRunner feeds the messages jobs push to its queue into a sink, using
+// the configured number of workers. Messages that still fail after the
+// retries go to the dead-letter store.
+type sinkRunner struct {
+	name        string
+	config      sink.SinkConfig
+	sink        sink.Sink
+	checker     sink.Checker
+	queue       *queue
+	workers     int
+	deadLetters *deadletter.Store
+	audit       *audit.Log
+	outboxPath  string
+	wg          sync.WaitGroup
+}
+
+func newSinkRunner(name string, cfg sink.SinkConfig, stores Stores) (*sinkRunner, error) {
+	s, err := sink.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	checker, _ := s.(sink.Checker)
+
+	return &sinkRunner{
+		name:        name,
+		config:      cfg,
+		sink:        timedSink{Sink: s, name: name},
+		checker:     checker,
+		workers:     cfg.Queue.WithDefaults().Workers,
+		deadLetters: stores.DeadLetters,
+		audit:       stores.Audit,
+		outboxPath:  outboxPath(stores.OutboxDir, name),
+	}, nil
+}
 
-func InitSink(cfgs map[string]sink.SinkConfig) {
-	for name, cfg := range cfgs {
-		sink, err := sink.New(cfg)
-		if err != nil {
-			panic(err)
+// open opens the queue. It is not done when the runner is built so that a
+// sink replaced during a reload does not touch its spill file or outbox
+// until the previous runner has let go of them.
+func (s *sinkRunner) open() error {
+	q, err := openQueue(s.config.Queue.WithDefaults(), s.outboxPath)
+	if err != nil {
+		return err
+	}
+	q.drop = s.dropped
+	q.keepDocs = s.audit != nil && s.audit.IncludeDocument()
+	s.queue = q
+
+	return nil
+}
+
+// discard releases the queue of a runner that was opened but not started.
+func (s *sinkRunner) discard() {
+	s.queue.discard()
+}
+
+// start opens the queue, unless open already did, and starts the workers.
+// A stopped runner can be started again. Once ctx is done, failed sends are
+// no longer retried.
+func (s *sinkRunner) start(ctx context.Context) error {
+	if s.queue == nil || s.queue.started {
+		if err := s.open(); err != nil {
+			return err
 		}
+	}
+	s.queue.start()
+
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for d := range s.queue.ch {
+				ctx := logger.With(tracing.Extract(ctx, d.Trace), slog.String("job", d.Message.ID), slog.String("sink", s.name))
+				if d.Level != nil {
+					ctx = logger.WithLevel(ctx, *d.Level)
+				}
+				if s.send(ctx, d) {
+					s.queue.Ack(d)
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// send delivers d, dead-lettering it if the retries run out. It returns
+// false if d could neither be sent nor dead-lettered, in which case it is
+// left in the outbox to be tried again after a restart.
+func (s *sinkRunner) send(ctx context.Context, d delivery) bool {
+	msg := d.Message
+
+	ctx, span := tracing.Tracer().Start(ctx, "sink.send", trace.WithAttributes(
+		attribute.String("job", msg.ID),
+		attribute.String("sink", s.name),
+		attribute.String("sink.type", s.config.SinkType),
+	))
+	attempts, err := sink.SendWithRetry(ctx, s.sink, msg, s.config.Retry)
+	span.SetAttributes(attribute.Int("attempts", attempts))
+	tracing.End(span, err)
+
+	metrics.Retries.WithLabelValues(s.name).Add(float64(attempts - 1))
+	if err == nil {
+		metrics.Sent.WithLabelValues(s.name).Inc()
+		s.record(ctx, d, audit.OutcomeSent, attempts, nil)
+		return true
+	}
+	metrics.SendFailures.WithLabelValues(s.name).Inc()
 
-		ch := make(chan label.Message)
-		sinkMaps[name] = ch
+	slog.WarnContext(ctx, "Failed to send alert", "attempts", attempts, "error", err)
 
-		go sink.AsyncSend(ch)
+	if s.deadLetters == nil {
+		metrics.Lost.WithLabelValues(s.name).Inc()
+		slog.ErrorContext(ctx, "alert lost, no dead-letter store", "attempts", attempts, "error", err)
+		s.record(ctx, d, audit.OutcomeFailed, attempts, err)
+		return true
+	}
+
+	e, perr := s.deadLetters.Put(deadletter.Entry{
+		Sink:     s.name,
+		Attempts: attempts,
+		Error:    logger.Redact(err.Error()),
+		Message:  msg,
+	})
+	if perr != nil {
+		slog.ErrorContext(ctx, "failed to dead-letter alert", "error", perr)
+		s.record(ctx, d, audit.OutcomeFailed, attempts, err)
+		return false
+	}
+	slog.InfoContext(ctx, "alert dead-lettered", "entry", e.ID)
+	s.record(ctx, d, audit.OutcomeDeadLettered, attempts, err)
+	return true
+}
+
+// dropped audits a message the queue dropped because it was full.
+func (s *sinkRunner) dropped(d delivery) {
+	s.record(context.Background(), d, audit.OutcomeDropped, 0, nil)
+}
+
+// record writes the outcome of d to the audit log, if there is one.
+func (s *sinkRunner) record(ctx context.Context, d delivery, outcome string, attempts int, err error) {
+	if s.audit == nil {
+		return
+	}
+
+	r := audit.Record{
+		Job:         d.Message.ID,
+		Sink:        s.name,
+		Outcome:     outcome,
+		Attempts:    attempts,
+		Labels:      d.Message.Labels,
+		Annotations: d.Message.Annotations,
+		Document:    d.Doc,
+	}
+	if err != nil {
+		r.Error = logger.Redact(err.Error())
+	}
+	if werr := s.audit.Write(r); werr != nil {
+		slog.ErrorContext(ctx, "failed to write audit record", "error", werr)
+	}
+}
+
+// timedSink records the latency of every send attempt.
+type timedSink struct {
+	sink.Sink
+	name string
+}
+
+func (t timedSink) Send(msg label.Message) error {
+	start := time.Now()
+	defer func() {
+		metrics.SendDuration.WithLabelValues(t.name).Observe(time.Since(start).Seconds())
+	}()
+
+	return t.Sink.Send(msg)
+}
+
+// stop closes the queue and waits for the messages already in it to be
+// sent. Jobs writing to the sink must be stopped first.
+func (s *sinkRunner) stop() {
+	s.queue.Close()
+	s.wg.Wait()
+	s.queue.closeOutbox()
+}
 
-		slog.Info("sink init success", "name", name)
+// outboxPath returns the log file of the named sink in dir.
+func outboxPath(dir string, name string) string {
+	if dir == "" {
+		return ""
 	}
+	return filepath.Join(dir, url.PathEscape(name)+".wal")
 }
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/label"
+)
+
+// testResult is what the test command prints for every document.
+type testResult struct {
+	Job         string            `json:"job"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Missing     []string          `json:"missing,omitempty"`
+	Errors      []string          `json:"errors,omitempty"`
+}
+
+// Test runs a job once and prints the messages it would produce without
+// sending anything to a sink. Documents come from the job's source over the
+// given window, or from a JSON fixture file.
+func Test(args []string) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	path := fs.String("config", "config.json", "config file or directory path")
+	name := fs.String("job", "", "name of the job to run")
+	fixture := fs.String("fixture", "", "JSON file with documents to use instead of the job source")
+	from := fs.String("from", "", "window start in RFC3339, defaults to now minus the job duration")
+	to := fs.String("to", "", "window end in RFC3339, defaults to now")
+	fs.Parse(args)
+
+	if err := runTest(*path, *name, *fixture, *from, *to, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runTest(path, name, fixture, from, to string, w io.Writer) error {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	var jobcfg *config.JobConfig
+	for i := range cfg.Job {
+		if cfg.Job[i].Name == name {
+			jobcfg = &cfg.Job[i]
+			break
+		}
+	}
+	if jobcfg == nil {
+		return errors.Errorf("job %q not found", name)
+	}
+
+	job, err := NewJob(*jobcfg, cfg.SharedTemplates())
+	if err != nil {
+		return err
+	}
+	defer job.Close()
+
+	window, err := job.testWindow(from, to)
+	if err != nil {
+		return err
+	}
+
+	var docs []map[string]interface{}
+	if fixture != "" {
+		docs, err = loadFixture(fixture)
+	} else {
+		docs, err = job.fetchWindow(context.Background(), window)
+	}
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	for _, d := range docs {
+		values, exprErr := job.extractor.Extract(d)
+		msg, err := job.buildMessage(values, d, window)
+
+		result := testResult{
+			Job:         msg.ID,
+			Labels:      msg.Labels,
+			Annotations: msg.Annotations,
+			Missing:     label.Missing(values),
+		}
+		for _, err := range []error{exprErr, err} {
+			if err == nil {
+				continue
+			}
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, e := range joined.Unwrap() {
+					result.Errors = append(result.Errors, e.Error())
+				}
+			} else {
+				result.Errors = append(result.Errors, err.Error())
+			}
+		}
+
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// testWindow parses the -from and -to flags. Empty bounds default to the
+// job's regular window ending now.
+func (j *Job) testWindow(from, to string) (label.Window, error) {
+	now := time.Now()
+	if to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return label.Window{}, errors.Wrap(err, "invalid -to")
+		}
+		now = t
+	}
+
+	start := now.Add(-time.Second * time.Duration(j.config.Duration))
+	if from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return label.Window{}, errors.Wrap(err, "invalid -from")
+		}
+		start = t
+	}
+
+	return label.Window{From: start, Now: now}, nil
+}
+
+// fetchWindow queries the job source once over window.
+func (j *Job) fetchWindow(ctx context.Context, window label.Window) ([]map[string]interface{}, error) {
+	start, now := window.From, window.Now
+
+	switch j.config.Type {
+	case "batch":
+		data, err := j.source.FetchOne(ctx, start, now)
+		if err != nil || data == nil {
+			return nil, err
+		}
+		return []map[string]interface{}{data}, nil
+	case "stream":
+		return j.source.FetchAll(ctx, start, now)
+	default:
+		return nil, errors.Errorf("unknown job type %s", j.config.Type)
+	}
+}
+
+// loadFixture reads documents from a JSON array or a stream of JSON objects.
+func loadFixture(path string) ([]map[string]interface{}, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read fixture")
+	}
+
+	var docs []map[string]interface{}
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		if err := json.Unmarshal(raw, &docs); err != nil {
+			return nil, errors.Wrap(err, "failed to parse fixture")
+		}
+		return docs, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	for {
+		var d map[string]interface{}
+		if err := dec.Decode(&d); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to parse fixture")
+		}
+		docs = append(docs, d)
+	}
+
+	return docs, nil
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunTest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	err := os.WriteFile(path, []byte(`{
+  "sink": {"am": {"sinkType": "alertmanager", "sinkConfig": {"host": "localhost:9093"}}},
+  "job": [{
+    "name": "job1",
+    "duration": 60,
+    "type": "stream",
+    "labels": {"host": "host.name", "user": "user.name"},
+    "annotations": {"summary": "login on {{ .host }}"},
+    "source": {
+      "sourceType": "elasticsearch",
+      "sourceConfig": {"address": "http://localhost:9200", "index": "logs", "query": "{}", "max": 10}
+    },
+    "sink": ["am"]
+  }]
+}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := filepath.Join(dir, "docs.json")
+	err = os.WriteFile(fixture, []byte(`{"host": {"name": "web-1"}, "user": {"name": "alice"}}
+{"host": {"name": "web-2"}}
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := runTest(path, "job1", fixture, "", "", &out); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []testResult
+	dec := json.NewDecoder(strings.NewReader(out.String()))
+	for dec.More() {
+		var r testResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("Unexpected result. Got: %s", out.String())
+	}
+	if got[0].Labels["user"] != "alice" || got[0].Annotations["summary"] != "login on web-1" || len(got[0].Missing) != 0 {
+		t.Errorf("Unexpected result. Got: %+v", got[0])
+	}
+	if len(got[1].Missing) != 1 || got[1].Missing[0] != "user" {
+		t.Errorf("Unexpected missing labels. Got: %v, Want: [user]", got[1].Missing)
+	}
+
+	if err := runTest(path, "nope", fixture, "", "", &out); err == nil || !strings.Contains(err.Error(), `job "nope" not found`) {
+		t.Errorf("Unexpected error. Got: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wanmail/alert-fetcher/checkpoint"
+	"github.com/wanmail/alert-fetcher/config"
+	"github.com/wanmail/alert-fetcher/sink"
+	"github.com/wanmail/alert-fetcher/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	tracing.Init(context.Background(), tracing.Config{Annotation: "trace_id"})
+	defer tracing.Init(context.Background(), tracing.Config{})
+
+	es, am, alerts := fakeServers(t, `{"host":{"name":"web-1"}}`)
+
+	job := testJob("traced", "am")
+	job.Duration = 1
+	job.SourceConfig.SourceConfig = json.RawMessage(`{"address":"` + es.URL + `","index":"logs","query":"{}","max":10}`)
+
+	checkpoints, _ := checkpoint.Open("")
+	a := NewApp(context.Background(), Stores{Checkpoints: checkpoints})
+	err := a.Apply(config.AppConfig{
+		Sink: map[string]sink.SinkConfig{"am": testSink(strings.TrimPrefix(am.URL, "http://"))},
+		Job:  []config.JobConfig{job},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var alert string
+	select {
+	case alert = <-alerts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No alert was sent")
+	}
+	a.Shutdown(5 * time.Second)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, s := range exporter.GetSpans() {
+		if _, ok := spans[s.Name]; !ok {
+			spans[s.Name] = s
+		}
+	}
+
+	tick, ok := spans["job.tick"]
+	if !ok {
+		t.Fatalf("Unexpected result. Got spans: %v, Want: job.tick", spans)
+	}
+	for _, name := range []string{"source.query", "extract", "sink.send"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("Unexpected result. Span %s is missing", name)
+			continue
+		}
+		if s.Parent.SpanID() != tick.SpanContext.SpanID() {
+			t.Errorf("Unexpected result. Span %s is not a child of job.tick", name)
+		}
+	}
+
+	traceID := tick.SpanContext.TraceID().String()
+	if !strings.Contains(alert, `"trace_id":"`+traceID+`"`) {
+		t.Errorf("Unexpected result. Got: %s, Want trace ID %s in annotations", alert, traceID)
+	}
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay coalesces the burst of events an editor produces on save.
+const watchDelay = 500 * time.Millisecond
+
+// Watch reloads the config at path whenever one of its files changes. The
+// directories holding the files are watched rather than the files, so that
+// editors replacing a file on save are picked up.
+func (a *App) Watch(path string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	watched := map[string]bool{}
+	addDirs := func() {
+		files := a.state().config.Files()
+
+		dirs := []string{}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		for _, f := range files {
+			dirs = append(dirs, filepath.Dir(f))
+		}
+		for _, d := range dirs {
+			if watched[d] {
+				continue
+			}
+			if err := w.Add(d); err != nil {
+				slog.Warn("failed to watch config directory", "path", d, "error", err)
+				continue
+			}
+			watched[d] = true
+		}
+	}
+	addDirs()
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-a.ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Has(fsnotify.Chmod) {
+				continue
+			}
+			timer = time.After(watchDelay)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Warn("config watch error", "error", err)
+		case <-timer:
+			timer = nil
+			a.Reload(path)
+			addDirs()
+		}
+	}
+}
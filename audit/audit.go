@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/logger"
+)
+
+// Outcomes of a message at a sink.
+const (
+	OutcomeSent         = "sent"
+	OutcomeFailed       = "failed"
+	OutcomeDeadLettered = "dead-lettered"
+	OutcomeDropped      = "dropped"
+)
+
+// Config sets up the audit log. It is off without a path.
+type Config struct {
+	Path string `json:"path"`
+
+	// IncludeDocument adds the source document of every alert to its
+	// record.
+	IncludeDocument bool `json:"includeDocument"`
+
+	Rotation logger.RotationConfig `json:"rotation"`
+}
+
+func (c Config) Validate() error {
+	if c.Path == "" && c.IncludeDocument {
+		return errors.New("audit path is required")
+	}
+	return c.Rotation.Validate()
+}
+
+// Record is what happened to one message at one sink.
+type Record struct {
+	Time        time.Time              `json:"time"`
+	Job         string                 `json:"job"`
+	Sink        string                 `json:"sink"`
+	Outcome     string                 `json:"outcome"`
+	Attempts    int                    `json:"attempts,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	Labels      map[string]string      `json:"labels"`
+	Annotations map[string]string      `json:"annotations,omitempty"`
+	Document    map[string]interface{} `json:"document,omitempty"`
+}
+
+// Log appends records to a rotated JSON lines file. Every record is synced
+// to disk as it is written, so the trail of the alerts already sent
+// survives the process or the machine crashing.
+type Log struct {
+	includeDocument bool
+
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// Open appends to the audit log at cfg.Path, rotating it as configured.
+func Open(cfg Config) *Log {
+	return &Log{
+		includeDocument: cfg.IncludeDocument,
+		w:               logger.OpenRotating(cfg.Path, cfg.Rotation),
+	}
+}
+
+// IncludeDocument reports whether records should carry the source
+// document.
+func (l *Log) IncludeDocument() bool {
+	return l.includeDocument
+}
+
+// Write appends r as one line.
+func (l *Log) Write(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if !l.includeDocument {
+		r.Document = nil
+	}
+
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.w.Write(append(raw, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write audit record")
+	}
+	return l.sync()
+}
+
+// sync flushes the log to disk, if its writer can. It is called with mu
+// held.
+func (l *Log) sync() error {
+	if s, ok := l.w.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync audit log")
+		}
+	}
+	return nil
+}
+
+// Close syncs the log to disk and closes it.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.sync()
+	if cerr := l.w.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log := Open(Config{Path: path})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: start, Job: "job1", Sink: "am", Outcome: OutcomeSent, Labels: map[string]string{"host": "web-1"}},
+		{Time: start.Add(time.Hour), Job: "job1", Sink: "am", Outcome: OutcomeFailed, Labels: map[string]string{"host": "web-2"}},
+		{Time: start.Add(2 * time.Hour), Job: "job2", Sink: "am", Outcome: OutcomeSent, Labels: map[string]string{"host": "web-1"},
+			Document: map[string]interface{}{"message": "hidden"}},
+	}
+	for _, r := range records {
+		if err := log.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "All", filter: Filter{}, want: []string{"job1", "job1", "job2"}},
+		{name: "Job", filter: Filter{Job: "job2"}, want: []string{"job2"}},
+		{name: "Outcome", filter: Filter{Outcome: OutcomeFailed}, want: []string{"job1"}},
+		{name: "Label", filter: Filter{Labels: map[string]string{"host": "web-1"}}, want: []string{"job1", "job2"}},
+		{name: "Time range", filter: Filter{From: start.Add(time.Hour), To: start.Add(2 * time.Hour)}, want: []string{"job1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			err := Query(path, test.filter, func(r Record) error {
+				if r.Document != nil {
+					t.Errorf("Unexpected document. Got: %v", r.Document)
+				}
+				got = append(got, r.Job)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("Unexpected result. Got: %v, Want: %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("Unexpected result. Got: %v, Want: %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryInvalidLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log := Open(Config{Path: path})
+	for _, job := range []string{"job1", "job2"} {
+		if err := log.Write(Record{Job: job, Sink: "am", Outcome: OutcomeSent}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a line cut short by a crash
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"job":"job3","si`)
+	f.Close()
+
+	var got []string
+	err = Query(path, Filter{}, func(r Record) error {
+		got = append(got, r.Job)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "job1" || got[1] != "job2" {
+		t.Errorf("Unexpected result. Got: %v, Want: %v", got, []string{"job1", "job2"})
+	}
+}
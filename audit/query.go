@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Filter selects records. Zero fields match everything.
+type Filter struct {
+	Job     string
+	Sink    string
+	Outcome string
+
+	// Labels must all be present with these values.
+	Labels map[string]string
+
+	// From and To bound the record time, From inclusive and To exclusive.
+	From time.Time
+	To   time.Time
+}
+
+// Match reports whether f selects r.
+func (f Filter) Match(r Record) bool {
+	switch {
+	case f.Job != "" && r.Job != f.Job,
+		f.Sink != "" && r.Sink != f.Sink,
+		f.Outcome != "" && r.Outcome != f.Outcome,
+		!f.From.IsZero() && r.Time.Before(f.From),
+		!f.To.IsZero() && !r.Time.Before(f.To):
+		return false
+	}
+
+	for k, v := range f.Labels {
+		if got, ok := r.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Query calls fn with every record matching f in the audit log at path,
+// including rotated and compressed files, oldest first.
+func Query(path string, f Filter, fn func(Record) error) error {
+	files, err := logFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := queryFile(file, f, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logFiles returns the rotated files of path by age, then path itself.
+// Rotated files are named like name-2006-01-02T15-04-05.000.ext[.gz].
+func logFiles(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "-"
+
+	var files []string
+	for _, pattern := range []string{prefix + "*" + ext, prefix + "*" + ext + ".gz"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+func queryFile(path string, f Filter, fn func(Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit log")
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			// a crash can leave the last line cut short
+			slog.Warn("skipping invalid audit record", "path", path, "line", line, "error", err)
+			continue
+		}
+		if !f.Match(rec) {
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Store keeps the start of the next window of every job, so that a
+// restarted process resumes where the previous one stopped. A Store with
+// no path only keeps checkpoints in memory.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	times map[string]time.Time
+	dirty bool
+}
+
+// Open loads the checkpoints saved at path. A missing file is not an error.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, times: make(map[string]time.Time)}
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read checkpoints")
+	}
+
+	if err := json.Unmarshal(raw, &s.times); err != nil {
+		return nil, errors.Wrapf(err, "invalid checkpoint file %s", path)
+	}
+
+	return s, nil
+}
+
+func (s *Store) Get(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.times[name]
+	return t, ok
+}
+
+func (s *Store) Set(name string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.times[name] = t
+	s.dirty = true
+}
+
+// Flush writes the checkpoints to disk if they changed since the last flush.
+// The file is replaced atomically so a crash never leaves it half written.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+
+	raw, err := json.MarshalIndent(s.times, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to write checkpoints")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write checkpoints")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write checkpoints")
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return errors.Wrap(err, "failed to write checkpoints")
+	}
+
+	s.dirty = false
+	return nil
+}
@@ -3,32 +3,414 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/audit"
 	"github.com/wanmail/alert-fetcher/logger"
 	"github.com/wanmail/alert-fetcher/sink"
+	"github.com/wanmail/alert-fetcher/source"
+	"github.com/wanmail/alert-fetcher/tracing"
 )
 
-// TODO: config reload
-// TODO: config format
+// DefaultShutdownTimeout is used when AppConfig.ShutdownTimeout is unset.
+const DefaultShutdownTimeout = 30
+
+// ServerConfig sets up the embedded HTTP server.
+type ServerConfig struct {
+	// Listen is the address to serve on, such as ":9090". Without it no
+	// server is started.
+	Listen string `json:"listen"`
+
+	// AdminToken, if set, must be sent as a bearer token to use the admin
+	// API. Without it the admin API is read-only.
+	AdminToken string `json:"adminToken"`
+}
 
 type AppConfig struct {
 	Log logger.LogConfig `json:"log"`
 
+	// Server is read once at startup; changing it needs a restart.
+	Server ServerConfig `json:"server"`
+
+	// Tracing is read once at startup; changing it needs a restart.
+	Tracing tracing.Config `json:"tracing"`
+
+	// Audit is read once at startup; changing it needs a restart.
+	Audit audit.Config `json:"audit"`
+
+	// CheckpointPath is the file job checkpoints are saved to. Without it
+	// jobs start from the current time after a restart.
+	CheckpointPath string `json:"checkpointPath"`
+
+	// DeadLetterPath is the directory messages are kept in once a sink has
+	// given up retrying them. Without it they are dropped.
+	DeadLetterPath string `json:"deadLetterPath"`
+
+	// OutboxPath is the directory of the write-ahead logs messages are kept
+	// in until their sink is done with them, so that they survive a crash.
+	// Job checkpoints then only advance once a window is in the outbox.
+	OutboxPath string `json:"outboxPath"`
+
+	// ShutdownTimeout bounds, in seconds, how long jobs and sinks may take to
+	// drain on shutdown.
+	ShutdownTimeout int `json:"shutdownTimeout"`
+
 	Sink map[string]sink.SinkConfig `json:"sink"`
 
+	// Sources holds named source presets that jobs refer to with source.ref.
+	Sources map[string]source.SourceConfig `json:"sources"`
+
+	// JobDefaults applies to every job; JobTemplates apply to the jobs that
+	// name them in template. The job's own settings take precedence.
+	JobDefaults  *JobConfig           `json:"jobDefaults"`
+	JobTemplates map[string]JobConfig `json:"jobTemplates"`
+
 	Job []JobConfig `json:"job"`
+
+	// Include lists glob patterns of further config files, relative to the
+	// file that includes them. Their sinks and jobs are merged into this one.
+	Include []string `json:"include"`
+
+	// Templates lists glob patterns of template files, relative to the file
+	// that lists them. The templates they {{ define }} can be used in the
+	// annotations of every job.
+	Templates []string `json:"templates"`
+
+	files     []string
+	secrets   []string
+	templates map[string]string
+
+	// defined maps the path of every singleton or named entry, such as
+	// "log" or "sink.am", to the file that defines it
+	defined map[string]string
+}
+
+// Secrets returns the values resolved from secret files and from
+// environment variables under credential-like keys.
+func (c AppConfig) Secrets() []string {
+	return c.secrets
+}
+
+// Dump renders the effective config as indented JSON with every secret and
+// credential-like value masked.
+func (c AppConfig) Dump() ([]byte, error) {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree interface{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(redactTree(tree, "", c.secrets), "", "  ")
+}
+
+func redactTree(tree interface{}, key string, secrets []string) interface{} {
+	switch t := tree.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			t[k] = redactTree(v, k, secrets)
+		}
+	case []interface{}:
+		for i, v := range t {
+			t[i] = redactTree(v, key, secrets)
+		}
+	case string:
+		if t != "" && sensitiveKey.MatchString(key) {
+			return logger.Redacted
+		}
+		for _, s := range secrets {
+			t = strings.ReplaceAll(t, s, logger.Redacted)
+		}
+		return t
+	}
+	return tree
+}
+
+// Files returns every file the config was loaded from, template files
+// included.
+func (c AppConfig) Files() []string {
+	return c.files
+}
+
+// SharedTemplates returns the contents of the template files, keyed by
+// file name.
+func (c AppConfig) SharedTemplates() map[string]string {
+	return c.templates
+}
+
+// LoadConfig reads and validates the config at path. The format is chosen
+// by extension: .yaml/.yml for YAML, .toml for TOML and JSON otherwise.
+// String values may reference ${ENV_VAR}, ${ENV_VAR:-default} or, as the
+// whole value, file:///path/to/secret.
+// If path is a directory every config file directly inside it is loaded and
+// merged, in name order. Any problem is returned as Errors with the file and
+// line it was found at.
+func LoadConfig(path string) (AppConfig, error) {
+	l := &loader{v: newValidator(), seen: map[string]bool{}, in: newInterpolator()}
+	cfg := AppConfig{
+		Sink:         map[string]sink.SinkConfig{},
+		Sources:      map[string]source.SourceConfig{},
+		JobTemplates: map[string]JobConfig{},
+		defined:      map[string]string{},
+		templates:    map[string]string{},
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return cfg, errors.Wrap(err, "failed to read config")
+	}
+
+	if info.IsDir() {
+		files, err := configFiles(path)
+		if err != nil {
+			return cfg, err
+		}
+		if len(files) == 0 {
+			return cfg, errors.Errorf("no config files found in %s", path)
+		}
+		for _, f := range files {
+			l.load(&cfg, f)
+		}
+	} else {
+		l.load(&cfg, path)
+	}
+
+	cfg.secrets = l.in.secrets
+
+	// semantic checks are meaningless on a partially decoded config
+	if l.broken {
+		return cfg, l.v.err()
+	}
+
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout
+	}
+
+	unresolved := l.v.expandJobs(&cfg)
+	l.v.validateApp(&cfg, unresolved)
+
+	return cfg, l.v.err()
+}
+
+// define records that file defines the entry at key, reporting an error if
+// another file already did.
+func (l *loader) define(cfg *AppConfig, key string, file string) bool {
+	if prev, ok := cfg.defined[key]; ok {
+		if section, name, ok := strings.Cut(key, "."); ok {
+			l.v.addf(file, key, "duplicate %s %q, first defined at %s", section, name, l.v.location(prev, key))
+		} else {
+			l.v.addf(file, key, "%s is already defined at %s", key, l.v.location(prev, key))
+		}
+		return false
+	}
+	cfg.defined[key] = file
+	return true
+}
+
+// configFiles lists the files in dir with a known config extension.
+func configFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config directory")
+	}
+
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".json", ".yaml", ".yml", ".toml":
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+
+	return files, nil
 }
 
-func LoadConfig(path string) AppConfig {
-	var cfg AppConfig
+type loader struct {
+	v    *validator
+	in   *interpolator
+	seen map[string]bool
+
+	// broken is set once any file fails to read, parse or decode
+	broken bool
+}
+
+// load decodes a single file, merges it into cfg and follows its includes.
+// Files already loaded are skipped, so overlapping globs and include cycles
+// are harmless.
+func (l *loader) load(cfg *AppConfig, path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		if l.seen[abs] {
+			return
+		}
+		l.seen[abs] = true
+	}
+
 	raw, err := os.ReadFile(path)
 	if err != nil {
-		panic("Error occured while reading config")
+		l.v.add(path, "", errors.Wrap(err, "failed to read config"))
+		l.broken = true
+		return
+	}
+	l.v.raw[path] = raw
+	cfg.files = append(cfg.files, path)
+
+	tree, pos, err := parserFor(path)(raw)
+	if err != nil {
+		l.v.addDecode(path, err)
+		l.broken = true
+		return
+	}
+	l.v.pos[path] = pos
+
+	var frag AppConfig
+	n := len(l.v.errs)
+	tree = l.in.resolve(tree, "", "", func(p string, err error) {
+		l.v.add(path, p, err)
+	})
+	l.v.checkKeys(path, tree, reflect.TypeOf(frag), "")
+	if len(l.v.errs) > n {
+		l.broken = true
+	}
+
+	// every format is decoded through JSON so that the raw sourceConfig and
+	// sinkConfig sections reach the plugins unchanged
+	data, err := json.Marshal(tree)
+	if err == nil {
+		err = json.Unmarshal(data, &frag)
+	}
+	if err != nil {
+		l.v.addDecode(path, err)
+		l.broken = true
+		return
+	}
+
+	l.merge(cfg, &frag, path)
+
+	for i, pattern := range frag.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			l.v.add(path, indexPath("include", i), err)
+			continue
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			l.v.addf(path, indexPath("include", i), "%s does not exist", pattern)
+			continue
+		}
+		for _, m := range matches {
+			l.load(cfg, m)
+		}
+	}
+}
+
+func (l *loader) merge(cfg *AppConfig, frag *AppConfig, path string) {
+	if !reflect.ValueOf(frag.Log).IsZero() && l.define(cfg, "log", path) {
+		cfg.Log = frag.Log
+	}
+
+	if frag.Server != (ServerConfig{}) && l.define(cfg, "server", path) {
+		cfg.Server = frag.Server
+	}
+
+	if !reflect.ValueOf(frag.Tracing).IsZero() && l.define(cfg, "tracing", path) {
+		cfg.Tracing = frag.Tracing
+	}
+
+	if !reflect.ValueOf(frag.Audit).IsZero() && l.define(cfg, "audit", path) {
+		cfg.Audit = frag.Audit
+	}
+
+	if frag.CheckpointPath != "" && l.define(cfg, "checkpointPath", path) {
+		cfg.CheckpointPath = frag.CheckpointPath
+	}
+
+	if frag.DeadLetterPath != "" && l.define(cfg, "deadLetterPath", path) {
+		cfg.DeadLetterPath = frag.DeadLetterPath
+	}
 
+	if frag.OutboxPath != "" && l.define(cfg, "outboxPath", path) {
+		cfg.OutboxPath = frag.OutboxPath
 	}
-	if err := json.Unmarshal(raw, &cfg); err != nil {
-		panic(err)
+
+	if frag.ShutdownTimeout != 0 && l.define(cfg, "shutdownTimeout", path) {
+		cfg.ShutdownTimeout = frag.ShutdownTimeout
+	}
+
+	if frag.JobDefaults != nil && l.define(cfg, "jobDefaults", path) {
+		cfg.JobDefaults = frag.JobDefaults
+	}
+
+	for name, s := range frag.Sink {
+		if l.define(cfg, joinPath("sink", name), path) {
+			cfg.Sink[name] = s
+		}
+	}
+
+	for name, s := range frag.Sources {
+		if l.define(cfg, joinPath("sources", name), path) {
+			cfg.Sources[name] = s
+		}
 	}
 
-	return cfg
+	for name, t := range frag.JobTemplates {
+		if l.define(cfg, joinPath("jobTemplates", name), path) {
+			cfg.JobTemplates[name] = t
+		}
+	}
+
+	for i, job := range frag.Job {
+		job.File = path
+		job.path = indexPath("job", i)
+		cfg.Job = append(cfg.Job, job)
+	}
+
+	cfg.Include = append(cfg.Include, frag.Include...)
+
+	for i, pattern := range frag.Templates {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		cfg.Templates = append(cfg.Templates, pattern)
+		l.loadTemplates(cfg, pattern, path, indexPath("templates", i))
+	}
+}
+
+// loadTemplates reads the template files matching pattern, listed at key in
+// file.
+func (l *loader) loadTemplates(cfg *AppConfig, pattern string, file string, key string) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		l.v.add(file, key, err)
+		return
+	}
+	if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		l.v.addf(file, key, "%s does not exist", pattern)
+		return
+	}
+
+	for _, m := range matches {
+		if _, ok := cfg.templates[m]; ok {
+			continue
+		}
+		raw, err := os.ReadFile(m)
+		if err != nil {
+			l.v.add(file, key, errors.Wrap(err, "failed to read template file"))
+			continue
+		}
+		cfg.templates[m] = string(raw)
+		cfg.files = append(cfg.files, m)
+	}
 }
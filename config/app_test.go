@@ -0,0 +1,583 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wanmail/alert-fetcher/source"
+)
+
+const validJob = `{
+      "name": "job1",
+      "duration": 60,
+      "type": "stream",
+      "labels": {"host": "host.name"},
+      "annotations": {"summary": "{{ .host }}"},
+      "source": {
+        "sourceType": "elasticsearch",
+        "sourceConfig": {"address": "http://localhost:9200", "index": "logs", "query": "{}", "max": 10}
+      },
+      "sink": ["am"]
+    }`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name: "Valid config",
+			content: `{
+  "sink": {"am": {"sinkType": "alertmanager", "sinkConfig": {"host": "localhost:9093"}}},
+  "job": [
+    ` + validJob + `
+  ]
+}`,
+		},
+		{
+			name: "Unknown key",
+			content: `{
+  "sink": {"am": {"sinkType": "alertmanager", "sinkConfig": {"host": "localhost:9093"}}},
+  "jobs": []
+}`,
+			expected: []string{":3: jobs: unknown key"},
+		},
+		{
+			name: "Unknown sink type and missing sink",
+			content: `{
+  "sink": {
+    "am": {"sinkType": "alertmanagr"}
+  },
+  "job": [
+    ` + strings.Replace(validJob, `["am"]`, `["am", "other"]`, 1) + `
+  ]
+}`,
+			expected: []string{
+				`:3: sink.am: invalid sink type "alertmanagr"`,
+				`:16: job[0].sink[1]: sink "other" is not defined`,
+			},
+		},
+		{
+			name: "Zero duration and duplicate name",
+			content: `{
+  "sink": {"am": {"sinkType": "alertmanager", "sinkConfig": {"host": "localhost:9093"}}},
+  "job": [
+    ` + strings.Replace(validJob, `"duration": 60`, `"duration": 0`, 1) + `,
+    ` + validJob + `
+  ]
+}`,
+			expected: []string{
+				":6: job[0].duration: duration must be between",
+				`:17: job[1].name: duplicate job name "job1"`,
+			},
+		},
+		{
+			name: "Bad template and label path",
+			content: `{
+  "sink": {"am": {"sinkType": "alertmanager", "sinkConfig": {"host": "localhost:9093"}}},
+  "job": [
+    ` + strings.NewReplacer(`"host.name"`, `"host.\"name"`, `{{ .host }}`, `{{ .host`).Replace(validJob) + `
+  ]
+}`,
+			expected: []string{
+				":8: job[0].labels.host: unbalanced",
+				":9: job[0].annotations.summary: template",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfig(t, test.content)
+			_, err := LoadConfig(path)
+
+			if len(test.expected) == 0 {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("Unexpected error type. Got: %v", err)
+			}
+			if len(errs) != len(test.expected) {
+				t.Fatalf("Unexpected errors. Got: %v, Want: %v", errs, test.expected)
+			}
+			for i, want := range test.expected {
+				if !strings.Contains(errs[i].Error(), path+want) {
+					t.Errorf("Unexpected error. Got: %s, Want: %s", errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `sink:
+  am:
+    sinkType: alertmanager
+    sinkConfig:
+      host: localhost:9093
+job:
+  - name: job1
+    duration: 60
+    type: stream
+    source:
+      sourceType: elasticsearch
+      sourceConfig:
+        address: http://localhost:9200
+        index: logs
+        max: 10
+        query:
+          term:
+            event.outcome: failure
+    sink: [am, other]
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	want := path + `:19: job[0].sink[1]: sink "other" is not defined`
+	if err == nil || err.Error() != want {
+		t.Fatalf("Unexpected error. Got: %v, Want: %s", err, want)
+	}
+
+	query := `"query":{"term":{"event.outcome":"failure"}}`
+	if !strings.Contains(string(cfg.Job[0].SourceConfig.SourceConfig), query) {
+		t.Errorf("Unexpected sourceConfig. Got: %s, Want query: %s", cfg.Job[0].SourceConfig.SourceConfig, query)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	content := `[sink.am]
+sinkType = "alertmanager"
+sinkConfig.host = "localhost:9093"
+
+[[job]]
+name = "job1"
+duration = 60
+type = "stream"
+sink = ["am"]
+source = { sourceType = "elasticsearch", sourceConfig = { address = "http://localhost:9200", index = "logs", query = "{}", max = 10 } }
+
+[[job]]
+name = "job2"
+duration = 60
+type = "stream"
+sink = [
+  "am",
+  "other",
+]
+
+[job.source]
+sourceType = "elasticsearch"
+sourceConfig = { address = "http://localhost:9200", index = "logs", query = "{}", max = 0 }
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadConfig(path)
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("Unexpected error type. Got: %v", err)
+	}
+	want := []string{
+		path + `:18: job[1].sink[1]: sink "other" is not defined`,
+		path + `:21: job[1].source: max must be greater than 0`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Unexpected errors. Got: %v, Want: %v", errs, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(errs[i].Error(), want[i]) {
+			t.Errorf("Unexpected error. Got: %s, Want: %s", errs[i], want[i])
+		}
+	}
+}
+
+func TestLoadConfigYAMLMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		merge   string
+		max     float64
+		wantErr string
+	}{
+		{name: "Mapping", merge: "*es", max: 10},
+		{name: "Sequence", merge: "[{max: 5}, *es]", max: 5},
+		{name: "Scalar", merge: "logs", wantErr: "merge key needs a mapping"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			content := `sink:
+  am:
+    sinkType: alertmanager
+    sinkConfig: {host: localhost:9093}
+job:
+  - name: job1
+    duration: 60
+    type: stream
+    sink: [am]
+    source:
+      sourceType: elasticsearch
+      sourceConfig: &es
+        address: http://localhost:9200
+        index: logs
+        query: "{}"
+        max: 10
+  - name: job2
+    duration: 60
+    type: stream
+    sink: [am]
+    source:
+      sourceType: elasticsearch
+      sourceConfig:
+        <<: ` + test.merge + `
+        index: auth
+`
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadConfig(path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Unexpected error. Got: %v, Want: %s", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(cfg.Job[1].SourceConfig.SourceConfig, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["index"] != "auth" || got["max"] != test.max || got["address"] != "http://localhost:9200" {
+				t.Errorf("Unexpected sourceConfig. Got: %v", got)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"00-root.yaml": `log:
+  logLevel: info
+sink:
+  am:
+    sinkType: alertmanager
+    sinkConfig:
+      host: localhost:9093
+include:
+  - jobs/*.json
+`,
+		"10-extra.json": `{
+  "job": [
+    ` + validJob + `
+  ]
+}`,
+		"jobs/job2.json": `{
+  "job": [
+    ` + strings.Replace(validJob, `"job1"`, `"job2"`, 1) + `
+  ]
+}`,
+		"jobs/dup.json": `{
+  "sink": {
+    "am": {"sinkType": "alertmanager", "sinkConfig": {"host": "other:9093"}}
+  },
+  "job": [
+    ` + validJob + `
+  ]
+}`,
+		"README.md": "not a config file",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := LoadConfig(dir)
+	// includes are loaded depth-first, so jobs/dup.json comes before 10-extra.json
+	want := []string{
+		filepath.Join(dir, "10-extra.json") + `:4: job[0].name: duplicate job name "job1", first defined at ` + filepath.Join(dir, "jobs/dup.json") + ":7",
+		filepath.Join(dir, "jobs/dup.json") + `:3: sink.am: duplicate sink "am", first defined at ` + filepath.Join(dir, "00-root.yaml") + ":4",
+	}
+	if err == nil || err.Error() != strings.Join(want, "\n") {
+		t.Fatalf("Unexpected error. Got: %v, Want: %s", err, strings.Join(want, "\n"))
+	}
+
+	if err := os.Remove(filepath.Join(dir, "jobs/dup.json")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cfg.Job) != 2 || cfg.Job[0].File != filepath.Join(dir, "jobs/job2.json") {
+		t.Errorf("Unexpected jobs. Got: %+v", cfg.Job)
+	}
+}
+
+func TestLoadConfigInterpolation(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "es-password")
+	if err := os.WriteFile(secret, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ES_ADDRESS", "http://es:9200")
+	t.Setenv("AM_TOKEN", "from-env")
+
+	path := writeConfig(t, `{
+  "sink": {"am": {"sinkType": "alertmanager", "sinkConfig": {"host": "${AM_HOST:-localhost:9093}", "basePath": "${AM_TOKEN}"}}},
+  "job": [
+    `+strings.NewReplacer(
+		`"http://localhost:9200"`, `"${ES_ADDRESS}", "password": "file://`+secret+`"`,
+		`{{ .host }}`, `$${literal}`,
+	).Replace(validJob)+`
+  ]
+}`)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	source := string(cfg.Job[0].SourceConfig.SourceConfig)
+	for _, want := range []string{`"address":"http://es:9200"`, `"password":"from-file"`} {
+		if !strings.Contains(source, want) {
+			t.Errorf("Unexpected sourceConfig. Got: %s, Want: %s", source, want)
+		}
+	}
+	if got := string(cfg.Sink["am"].SinkConfig); !strings.Contains(got, `"host":"localhost:9093"`) {
+		t.Errorf("Default not applied. Got: %s", got)
+	}
+	if got := cfg.Job[0].Annotations["summary"]; got != "${literal}" {
+		t.Errorf("Unexpected escape. Got: %s", got)
+	}
+
+	// basePath is not a credential key, so its env value is not a secret
+	if got := cfg.Secrets(); !reflect.DeepEqual(got, []string{"from-file"}) {
+		t.Errorf("Unexpected secrets. Got: %v", got)
+	}
+
+	dump, err := cfg.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(dump), "from-file") || !strings.Contains(string(dump), `"password": "******"`) {
+		t.Errorf("Secret not redacted in dump: %s", dump)
+	}
+
+	os.Unsetenv("ES_ADDRESS")
+	_, err = LoadConfig(path)
+	want := path + ":12: job[0].source.sourceConfig.address: environment variable ES_ADDRESS is not set"
+	if err == nil || err.Error() != want {
+		t.Errorf("Unexpected error. Got: %v, Want: %s", err, want)
+	}
+}
+
+func TestResolveString(t *testing.T) {
+	env := map[string]string{"SET": "value", "EMPTY": ""}
+	in := &interpolator{lookup: func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}}
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "${SET}", want: "value"},
+		{in: "${EMPTY}", want: ""},
+		{in: "${UNSET}", want: "${UNSET}", wantErr: true},
+		{in: "${SET:-default}", want: "value"},
+		{in: "${EMPTY:-default}", want: "default"},
+		{in: "${UNSET:-default}", want: "default"},
+		{in: "$${SET}", want: "${SET}"},
+	}
+
+	for _, tt := range tests {
+		got, err := in.resolveString(tt.in, false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unexpected error for %s: %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("Unexpected result. Got: %v, Want: %v", got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `sink:
+  am:
+    sinkType: alertmanager
+    sinkConfig: {host: localhost:9093}
+sources:
+  es:
+    sourceType: elasticsearch
+    sourceConfig:
+      address: http://localhost:9200
+      index: logs-*
+      max: 100
+jobDefaults:
+  duration: 60
+  type: stream
+  sink: [am]
+  staticLabels: {team: sec}
+jobTemplates:
+  auth:
+    labels: {user: user.name}
+    annotations: {summary: "{{ .user }}"}
+    source:
+      ref: es
+      sourceConfig: {index: auth-*}
+job:
+  - name: job1
+    template: auth
+    staticLabels: {severity: high}
+    source:
+      sourceConfig:
+        query: {term: {event.outcome: failure}}
+  - name: job2
+    duration: 300
+    source:
+      ref: es
+      sourceConfig: {query: "{}", max: 5}
+  - name: job3
+    template: missing
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadConfig(path)
+	want := path + `:37: job[2].template: job template "missing" is not defined`
+	if err == nil || err.Error() != want {
+		t.Fatalf("Unexpected error. Got: %v, Want: %s", err, want)
+	}
+
+	content = strings.Replace(content, "  - name: job3\n    template: missing\n", "", 1)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	job1, job2 := cfg.Job[0], cfg.Job[1]
+	if job1.Duration != 60 || job1.Type != "stream" || !reflect.DeepEqual(job1.Sink, []string{"am"}) {
+		t.Errorf("Defaults not applied: %+v", job1)
+	}
+	if !reflect.DeepEqual(job1.StaticLabels, map[string]string{"team": "sec", "severity": "high"}) {
+		t.Errorf("Unexpected static labels: %v", job1.StaticLabels)
+	}
+	if !reflect.DeepEqual(job1.Labels, map[string]string{"user": "user.name"}) {
+		t.Errorf("Template labels not applied: %v", job1.Labels)
+	}
+	if job2.Duration != 300 || job2.Labels != nil {
+		t.Errorf("Unexpected job2: %+v", job2)
+	}
+
+	tests := []struct {
+		job      JobConfig
+		expected string
+	}{
+		{job1, `{"address":"http://localhost:9200","index":"auth-*","max":100,"query":{"term":{"event.outcome":"failure"}}}`},
+		{job2, `{"address":"http://localhost:9200","index":"logs-*","max":5,"query":"{}"}`},
+	}
+	for _, test := range tests {
+		if test.job.SourceConfig.SourceType != "elasticsearch" || string(test.job.SourceConfig.SourceConfig) != test.expected {
+			t.Errorf("Unexpected source for %s. Got: %s %s, Want: %s", test.job.Name, test.job.SourceConfig.SourceType, test.job.SourceConfig.SourceConfig, test.expected)
+		}
+	}
+}
+
+func TestMergeJob(t *testing.T) {
+	base := JobConfig{SourceConfig: source.SourceConfig{SourceType: "elasticsearch", SourceConfig: json.RawMessage(`{"index":"logs","max":10}`)}}
+
+	got, err := mergeJob(base, JobConfig{SourceConfig: source.SourceConfig{SourceConfig: json.RawMessage(`{"max":5}`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"index":"logs","max":5}`; string(got.SourceConfig.SourceConfig) != want {
+		t.Errorf("Unexpected result. Got: %s, Want: %s", got.SourceConfig.SourceConfig, want)
+	}
+
+	if _, err := mergeJob(base, JobConfig{SourceConfig: source.SourceConfig{SourceConfig: json.RawMessage(`{"max":`)}}); err == nil {
+		t.Errorf("Expected an error for an invalid source override")
+	}
+}
+
+func TestLoadConfigSharedTemplates(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "templates", "common.tmpl")
+	if err := os.MkdirAll(filepath.Dir(tmpl), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tmpl, []byte(`{{ define "host" }}{{ .host }}{{ end }}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	content := `{
+  "templates": ["templates/*.tmpl"],
+  "sink": {"am": {"sinkType": "alertmanager", "sinkConfig": {"host": "localhost:9093"}}},
+  "job": [
+    ` + strings.Replace(validJob, `{{ .host }}`, `{{ template \"host\" . }}`, 1) + `
+  ]
+}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := cfg.SharedTemplates(); len(got) != 1 || got[tmpl] == "" {
+		t.Errorf("Unexpected templates. Got: %v", got)
+	}
+	if got := cfg.Files(); len(got) != 2 || got[1] != tmpl {
+		t.Errorf("Unexpected files. Got: %v", got)
+	}
+
+	if err := os.WriteFile(tmpl, []byte(`{{ define "host" }}{{ .host }}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(path)
+	if err == nil || !strings.HasPrefix(err.Error(), tmpl+": invalid template file") {
+		t.Errorf("Unexpected error. Got: %v", err)
+	}
+}
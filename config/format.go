@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// parser decodes a config file into a generic tree of maps, slices and
+// scalars, along with the line every path starts on where the format
+// allows it.
+type parser func(raw []byte) (interface{}, positions, error)
+
+// parserFor picks the config format from the file extension. Anything that
+// is not YAML or TOML is read as JSON.
+func parserFor(path string) parser {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAML
+	case ".toml":
+		return parseTOML
+	default:
+		return parseJSON
+	}
+}
+
+func parseJSON(raw []byte) (interface{}, positions, error) {
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, nil, err
+	}
+
+	return tree, jsonPositions(raw), nil
+}
+
+func parseYAML(raw []byte) (interface{}, positions, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	p := positions{}
+	if len(doc.Content) == 0 {
+		return map[string]interface{}{}, p, nil
+	}
+
+	tree, err := walkYAML(doc.Content[0], "", p)
+	return tree, p, err
+}
+
+func walkYAML(n *yaml.Node, path string, p positions) (interface{}, error) {
+	if _, ok := p[path]; !ok {
+		p[path] = n.Line
+	}
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return walkYAML(n.Content[0], path, p)
+	case yaml.AliasNode:
+		return walkYAML(n.Alias, path, p)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			// merge keys (<<: *base or <<: [*a, *b]) copy the aliased
+			// mappings in place, the first one winning
+			if k.Tag == "!!merge" {
+				sources := []*yaml.Node{v}
+				if v.Kind == yaml.SequenceNode {
+					sources = v.Content
+				}
+				for _, src := range sources {
+					merged, err := walkYAML(src, path, p)
+					if err != nil {
+						return nil, err
+					}
+					mm, ok := merged.(map[string]interface{})
+					if !ok {
+						return nil, errors.Errorf("line %d: merge key needs a mapping or a list of mappings", src.Line)
+					}
+					for mk, mv := range mm {
+						if _, ok := m[mk]; !ok {
+							m[mk] = mv
+						}
+					}
+				}
+				continue
+			}
+			child := joinPath(path, k.Value)
+			p[child] = k.Line
+			val, err := walkYAML(v, child, p)
+			if err != nil {
+				return nil, err
+			}
+			m[k.Value] = val
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]interface{}, 0, len(n.Content))
+		for i, c := range n.Content {
+			val, err := walkYAML(c, indexPath(path, i), p)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, val)
+		}
+		return s, nil
+	default:
+		var val interface{}
+		if err := n.Decode(&val); err != nil {
+			return nil, errors.Wrapf(err, "line %d", n.Line)
+		}
+		return val, nil
+	}
+}
+
+func parseTOML(raw []byte) (interface{}, positions, error) {
+	var tree map[string]interface{}
+	if err := toml.Unmarshal(raw, &tree); err != nil {
+		return nil, nil, err
+	}
+
+	return tree, tomlPositions(raw), nil
+}
+
+// fieldPath converts an encoding/json field path like "job.0.duration" into
+// the config path notation "job[0].duration".
+func fieldPath(field string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(field, ".") {
+		var n int
+		if _, err := fmt.Sscanf(part, "%d", &n); err == nil && fmt.Sprint(n) == part {
+			fmt.Fprintf(&b, "[%d]", n)
+			continue
+		}
+		if i > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
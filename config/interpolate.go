@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const filePrefix = "file://"
+
+var (
+	// ${VAR} or ${VAR:-default}; $${ escapes a literal ${
+	envPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+	// keys whose values are treated as secrets
+	sensitiveKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|apikey|api_key|credential|auth)`)
+)
+
+// interpolator resolves environment and secret file references in a decoded
+// config tree, remembering the values that must not be shown.
+type interpolator struct {
+	secrets []string
+	lookup  func(string) (string, bool)
+	read    func(string) ([]byte, error)
+}
+
+func newInterpolator() *interpolator {
+	return &interpolator{lookup: os.LookupEnv, read: os.ReadFile}
+}
+
+// resolve walks tree in place. key is the map key the value sits under and
+// decides whether an interpolated value counts as a secret; file references
+// always do. err is called for every reference that cannot be resolved.
+func (in *interpolator) resolve(tree interface{}, key string, path string, err func(path string, e error)) interface{} {
+	switch t := tree.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			t[k] = in.resolve(v, k, joinPath(path, k), err)
+		}
+		return t
+	case []interface{}:
+		for i, v := range t {
+			t[i] = in.resolve(v, key, indexPath(path, i), err)
+		}
+		return t
+	case string:
+		s, e := in.resolveString(t, sensitiveKey.MatchString(key))
+		if e != nil {
+			err(path, e)
+		}
+		return s
+	default:
+		return tree
+	}
+}
+
+func (in *interpolator) resolveString(s string, sensitive bool) (string, error) {
+	if strings.HasPrefix(s, filePrefix) {
+		raw, err := in.read(strings.TrimPrefix(s, filePrefix))
+		if err != nil {
+			return s, errors.Wrap(err, "failed to read secret file")
+		}
+		secret := strings.TrimRight(string(raw), "\r\n")
+		in.addSecret(secret)
+		return secret, nil
+	}
+
+	var err error
+	out := envPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+
+		m := envPattern.FindStringSubmatch(ref)
+		val, ok := in.lookup(m[1])
+		if m[2] != "" && val == "" {
+			// like the shell, ${VAR:-default} treats an empty VAR as unset;
+			// defaults are written in the config itself, so are not secret
+			return m[3]
+		}
+		if !ok {
+			if err == nil {
+				err = errors.Errorf("environment variable %s is not set", m[1])
+			}
+			return ref
+		}
+
+		if sensitive {
+			in.addSecret(val)
+		}
+		return val
+	})
+
+	return out, err
+}
+
+func (in *interpolator) addSecret(s string) {
+	if s == "" {
+		return
+	}
+	for _, v := range in.secrets {
+		if v == s {
+			return
+		}
+	}
+	in.secrets = append(in.secrets, s)
+}
@@ -1,20 +1,74 @@
 package config
 
 import (
+	"reflect"
+
+	"github.com/wanmail/alert-fetcher/label"
 	"github.com/wanmail/alert-fetcher/source"
 )
 
+// Bounds for JobConfig.Duration, in seconds.
+const (
+	MinDuration = 1
+	MaxDuration = 24 * 60 * 60
+)
+
 type JobConfig struct {
+	// Template names an entry of AppConfig.JobTemplates to start from.
+	Template string `json:"template"`
+
 	Name     string `json:"name"`
 	Duration int    `json:"duration"`
 
+	// Labels are field paths by label name, like host.name. A key holding
+	// a dot or a [ is quoted, as in "tags[0]": unquoted, [...] selects
+	// array elements, like tags[0], tags[*] or ips[?type=="ipv4"].value.
 	Labels       map[string]string `json:"labels"`
 	StaticLabels map[string]string `json:"staticLabels"`
 	Annotations  map[string]string `json:"annotations"`
 
+	// LabelExprs are labels computed by expressions over the source
+	// document, by label name, e.g. status >= 500 ? "critical" : "warning".
+	// Fields are only typed once a document is seen, so a field of the
+	// wrong type fails on that document rather than when the config loads.
+	LabelExprs map[string]string `json:"labelExprs"`
+
+	// LabelFormats sets how the values of labels are rendered, by label
+	// name.
+	LabelFormats map[string]label.Format `json:"labelFormats"`
+
+	// FieldLookup is how label paths are matched: nested, the default, or
+	// flattened to also match literal dotted keys like "host.name".
+	FieldLookup string `json:"fieldLookup"`
+
+	// LabelSeparator joins the values of labels whose path selects several,
+	// such as tags[*]. It defaults to a comma.
+	LabelSeparator string `json:"labelSeparator"`
+
+	// TemplateMode is how annotations are rendered: text, the default, or
+	// html to escape values for receivers that render HTML.
+	TemplateMode string `json:"templateMode"`
+
 	Type string `json:"type"`
 
 	SourceConfig source.SourceConfig `json:"source"`
 
 	Sink []string `json:"sink"`
+
+	// LogLevel overrides the global log level for the logs of this job.
+	LogLevel string `json:"logLevel"`
+
+	// File is the config file the job was loaded from.
+	File string `json:"-"`
+
+	// path locates the job within File, e.g. "job[2]".
+	path string
+}
+
+// Equal reports whether c and o describe the same job, regardless of the
+// file they were loaded from.
+func (c JobConfig) Equal(o JobConfig) bool {
+	c.File, c.path = "", ""
+	o.File, o.path = "", ""
+	return reflect.DeepEqual(c, o)
 }
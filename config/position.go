@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// positions maps config paths such as "job[0].sink[1]" to the line of the
+// file they start on.
+type positions map[string]int
+
+// line returns the line of path, or of its closest ancestor when path itself
+// is not present in the file (e.g. a missing required key).
+func (p positions) line(path string) int {
+	for {
+		if l, ok := p[path]; ok {
+			return l
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			return p[""]
+		}
+		path = path[:i]
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// lineAt converts a byte offset into a 1-based line number.
+func lineAt(raw []byte, offset int64) int {
+	if offset > int64(len(raw)) {
+		offset = int64(len(raw))
+	}
+	return bytes.Count(raw[:offset], []byte("\n")) + 1
+}
+
+// jsonPositions records the line of every key and array element in raw.
+// Syntax errors stop the walk; they are reported by the decoder itself.
+func jsonPositions(raw []byte) positions {
+	p := positions{}
+	walkJSON(json.NewDecoder(bytes.NewReader(raw)), raw, "", p)
+	return p
+}
+
+func walkJSON(dec *json.Decoder, raw []byte, path string, p positions) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if _, ok := p[path]; !ok {
+		p[path] = lineAt(raw, dec.InputOffset())
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			child := joinPath(path, fmt.Sprint(key))
+			p[child] = lineAt(raw, dec.InputOffset())
+			if err := walkJSON(dec, raw, child, p); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := walkJSON(dec, raw, indexPath(path, i), p); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+
+	return err
+}
+
+// tomlPositions records the line of every key, table and array element in
+// raw. Tables may be reopened and extended with dotted keys, so paths are
+// resolved through the array tables seen so far: [job.source] after the
+// second [[job]] is "job[1].source".
+func tomlPositions(raw []byte) positions {
+	p := positions{"": 1}
+	arrays := map[string]int{}
+
+	var parser unstable.Parser
+	parser.Reset(raw)
+
+	line := func(n *unstable.Node) int {
+		return lineAt(raw, int64(n.Raw.Offset))
+	}
+	// resolve appends the key of n to path, recording every segment
+	resolve := func(path string, n *unstable.Node) string {
+		it := n.Key()
+		for it.Next() {
+			path = joinPath(path, string(it.Node().Data))
+			if _, ok := p[path]; !ok {
+				p[path] = line(it.Node())
+			}
+			if i, ok := arrays[path]; ok {
+				path = indexPath(path, i-1)
+			}
+		}
+		return path
+	}
+
+	var walkValue func(n *unstable.Node, path string)
+	walkKeyValue := func(n *unstable.Node, table string) {
+		path := resolve(table, n)
+		walkValue(n.Value(), path)
+	}
+	walkValue = func(n *unstable.Node, path string) {
+		switch n.Kind {
+		case unstable.InlineTable:
+			it := n.Children()
+			for it.Next() {
+				walkKeyValue(it.Node(), path)
+			}
+		case unstable.Array:
+			it := n.Children()
+			for i := 0; it.Next(); i++ {
+				child := indexPath(path, i)
+				if it.Node().Raw.Length > 0 {
+					p[child] = line(it.Node())
+				}
+				walkValue(it.Node(), child)
+			}
+		}
+	}
+
+	table := ""
+	for parser.NextExpression() {
+		n := parser.Expression()
+		switch n.Kind {
+		case unstable.Table:
+			table = resolve("", n)
+		case unstable.ArrayTable:
+			// the key resolves to the previous element, if any
+			it := n.Key()
+			path := ""
+			for it.Next() {
+				path = joinPath(path, string(it.Node().Data))
+				if _, ok := p[path]; !ok {
+					p[path] = line(it.Node())
+				}
+				if i, ok := arrays[path]; ok && !it.IsLast() {
+					path = indexPath(path, i-1)
+				}
+			}
+			i := arrays[path]
+			arrays[path] = i + 1
+			table = indexPath(path, i)
+			p[table] = line(n.Child())
+		case unstable.KeyValue:
+			walkKeyValue(n, table)
+		}
+	}
+
+	return p
+}
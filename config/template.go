@@ -0,0 +1,182 @@
+package config
+
+import (
+	"encoding/json"
+
+	"github.com/wanmail/alert-fetcher/source"
+)
+
+// expandJobs resolves every job against jobDefaults, its template and its
+// source preset, in that order of increasing precedence. It returns the
+// indexes of jobs that could not be resolved.
+func (v *validator) expandJobs(cfg *AppConfig) map[int]bool {
+	failed := make(map[int]bool)
+	for i := range cfg.Job {
+		job := &cfg.Job[i]
+
+		expanded := JobConfig{}
+		if cfg.JobDefaults != nil {
+			expanded = *cfg.JobDefaults
+		}
+
+		if job.Template != "" {
+			tpl, ok := cfg.JobTemplates[job.Template]
+			if !ok {
+				v.addf(job.File, joinPath(job.path, "template"), "job template %q is not defined", job.Template)
+				failed[i] = true
+				continue
+			}
+			merged, err := mergeJob(expanded, tpl)
+			if err != nil {
+				v.add(job.File, joinPath(job.path, "template"), err)
+				failed[i] = true
+				continue
+			}
+			expanded = merged
+		}
+
+		merged, err := mergeJob(expanded, *job)
+		if err != nil {
+			v.add(job.File, joinPath(job.path, "source"), err)
+			failed[i] = true
+			continue
+		}
+		expanded = merged
+
+		if ref := expanded.SourceConfig.Ref; ref != "" {
+			preset, ok := cfg.Sources[ref]
+			if !ok {
+				v.addf(job.File, joinPath(job.path, "source.ref"), "source %q is not defined", ref)
+				failed[i] = true
+				continue
+			}
+			merged, err := mergeSource(preset, expanded.SourceConfig)
+			if err != nil {
+				v.add(job.File, joinPath(job.path, "source"), err)
+				failed[i] = true
+				continue
+			}
+			expanded.SourceConfig = merged
+		}
+
+		*job = expanded
+	}
+
+	return failed
+}
+
+// mergeJob overlays the settings over sets on base. Label and annotation maps
+// are merged key by key; everything else is replaced when set. It fails if
+// the source configs can't be merged.
+func mergeJob(base JobConfig, over JobConfig) (JobConfig, error) {
+	out := over
+	out.Labels = mergeMap(base.Labels, over.Labels)
+	out.StaticLabels = mergeMap(base.StaticLabels, over.StaticLabels)
+	out.Annotations = mergeMap(base.Annotations, over.Annotations)
+	out.LabelExprs = mergeMap(base.LabelExprs, over.LabelExprs)
+	out.LabelFormats = mergeMap(base.LabelFormats, over.LabelFormats)
+
+	if over.Duration == 0 {
+		out.Duration = base.Duration
+	}
+	if over.Type == "" {
+		out.Type = base.Type
+	}
+	if len(over.Sink) == 0 {
+		out.Sink = base.Sink
+	}
+	if over.FieldLookup == "" {
+		out.FieldLookup = base.FieldLookup
+	}
+	if over.LabelSeparator == "" {
+		out.LabelSeparator = base.LabelSeparator
+	}
+	if over.TemplateMode == "" {
+		out.TemplateMode = base.TemplateMode
+	}
+	if over.LogLevel == "" {
+		out.LogLevel = base.LogLevel
+	}
+
+	src, err := mergeSource(base.SourceConfig, over.SourceConfig)
+	if err != nil {
+		return out, err
+	}
+	out.SourceConfig = src
+
+	return out, nil
+}
+
+func mergeMap[V any](base map[string]V, over map[string]V) map[string]V {
+	if base == nil {
+		return over
+	}
+
+	out := make(map[string]V, len(base)+len(over))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range over {
+		out[k] = v
+	}
+	return out
+}
+
+// mergeSource overlays over on base, deep merging the raw plugin configs so
+// a job can override a single query setting of a shared connection.
+func mergeSource(base source.SourceConfig, over source.SourceConfig) (source.SourceConfig, error) {
+	out := base
+	if over.Ref != "" {
+		out.Ref = over.Ref
+	}
+	if over.SourceType != "" {
+		out.SourceType = over.SourceType
+	}
+
+	raw, err := mergeRaw(base.SourceConfig, over.SourceConfig)
+	if err != nil {
+		return out, err
+	}
+	out.SourceConfig = raw
+
+	return out, nil
+}
+
+func mergeRaw(base json.RawMessage, over json.RawMessage) (json.RawMessage, error) {
+	if len(base) == 0 {
+		return over, nil
+	}
+	if len(over) == 0 {
+		return base, nil
+	}
+
+	var b, o interface{}
+	if err := json.Unmarshal(base, &b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(over, &o); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergeTree(b, o))
+}
+
+func mergeTree(base interface{}, over interface{}) interface{} {
+	bm, ok := base.(map[string]interface{})
+	if !ok {
+		return over
+	}
+	om, ok := over.(map[string]interface{})
+	if !ok {
+		return over
+	}
+
+	out := make(map[string]interface{}, len(bm)+len(om))
+	for k, v := range bm {
+		out[k] = v
+	}
+	for k, v := range om {
+		out[k] = mergeTree(bm[k], v)
+	}
+	return out
+}
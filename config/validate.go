@@ -0,0 +1,339 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/label"
+	"github.com/wanmail/alert-fetcher/logger"
+	"github.com/wanmail/alert-fetcher/sink"
+	"github.com/wanmail/alert-fetcher/source"
+)
+
+// Error is a configuration problem tied to a location in a config file.
+type Error struct {
+	File string
+	Line int
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	loc := e.File
+	if e.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", e.File, e.Line)
+	}
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %v", loc, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", loc, e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors collects every problem found in a config.
+type Errors []*Error
+
+func (es Errors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// validator accumulates errors across every file of a config.
+type validator struct {
+	raw  map[string][]byte
+	pos  map[string]positions
+	errs Errors
+}
+
+func newValidator() *validator {
+	return &validator{raw: map[string][]byte{}, pos: map[string]positions{}}
+}
+
+func (v *validator) add(file string, path string, err error) {
+	v.errs = append(v.errs, &Error{File: file, Line: v.pos[file].line(path), Path: path, Err: err})
+}
+
+func (v *validator) addf(file string, path string, format string, args ...interface{}) {
+	v.add(file, path, errors.Errorf(format, args...))
+}
+
+// location formats file and path as "file:line" for cross references.
+func (v *validator) location(file string, path string) string {
+	if l := v.pos[file].line(path); l > 0 {
+		return fmt.Sprintf("%s:%d", file, l)
+	}
+	return file
+}
+
+// addDecode records a parser or decoder error with the best location it
+// carries: a JSON byte offset, a TOML row, or the path of a mistyped field.
+func (v *validator) addDecode(file string, err error) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tomlErr *toml.DecodeError
+
+	e := &Error{File: file, Err: err}
+	switch {
+	case errors.As(err, &syntaxErr):
+		e.Line = lineAt(v.raw[file], syntaxErr.Offset)
+	case errors.As(err, &tomlErr):
+		e.Line, _ = tomlErr.Position()
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		e.Path = fieldPath(typeErr.Field)
+		e.Line = v.pos[file].line(e.Path)
+		e.Err = errors.Errorf("cannot use %s as %s", typeErr.Value, typeErr.Type)
+	}
+	v.errs = append(v.errs, e)
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		a, b := v.errs[i], v.errs[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Path < b.Path
+	})
+	return v.errs
+}
+
+var (
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// checkKeys reports keys in the decoded tree that have no matching field in
+// t. Raw plugin sections and types with their own decoding are skipped;
+// plugins check those themselves.
+func (v *validator) checkKeys(file string, tree interface{}, t reflect.Type, path string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == rawMessageType || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := tree.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for key, val := range m {
+			f, ok := lookupField(fields, key)
+			if !ok {
+				v.addf(file, joinPath(path, key), "unknown key %q", key)
+				continue
+			}
+			v.checkKeys(file, val, f.Type, joinPath(path, key))
+		}
+	case reflect.Map:
+		m, ok := tree.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, val := range m {
+			v.checkKeys(file, val, t.Elem(), joinPath(path, key))
+		}
+	case reflect.Slice:
+		s, ok := tree.([]interface{})
+		if !ok {
+			return
+		}
+		for i, val := range s {
+			v.checkKeys(file, val, t.Elem(), indexPath(path, i))
+		}
+	}
+}
+
+// jsonFields returns the fields of t keyed by their JSON name, flattening
+// embedded structs the way encoding/json does.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for k, ef := range jsonFields(f.Type) {
+				if _, ok := fields[k]; !ok {
+					fields[k] = ef
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// lookupField matches key like encoding/json: exact first, then
+// case-insensitively.
+func lookupField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if f, ok := fields[key]; ok {
+		return f, true
+	}
+	for name, f := range fields {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// validateApp checks the loaded config. Jobs in skip already failed to
+// resolve and are only checked for their name.
+func (v *validator) validateApp(cfg *AppConfig, skip map[int]bool) {
+	if cfg.ShutdownTimeout < 0 {
+		v.addf(cfg.defined["shutdownTimeout"], "shutdownTimeout", "shutdownTimeout must not be negative, got %d", cfg.ShutdownTimeout)
+	}
+
+	if err := cfg.Log.Validate(); err != nil {
+		v.add(cfg.defined["log"], "log", err)
+	}
+
+	if err := cfg.Tracing.Validate(); err != nil {
+		v.add(cfg.defined["tracing"], "tracing", err)
+	}
+
+	if err := cfg.Audit.Validate(); err != nil {
+		v.add(cfg.defined["audit"], "audit", err)
+	}
+
+	sharedOK := true
+	for name, text := range cfg.templates {
+		if err := label.CheckShared(map[string]string{name: text}); err != nil {
+			v.add(name, "", err)
+			sharedOK = false
+		}
+	}
+
+	for name, s := range cfg.Sink {
+		if err := sink.Validate(s); err != nil {
+			v.add(cfg.defined[joinPath("sink", name)], joinPath("sink", name), err)
+		}
+	}
+
+	names := make(map[string]*JobConfig)
+	for i := range cfg.Job {
+		job := &cfg.Job[i]
+
+		if job.Name == "" {
+			v.addf(job.File, job.path, "name is required")
+		} else if prev, ok := names[job.Name]; ok {
+			v.addf(job.File, joinPath(job.path, "name"), "duplicate job name %q, first defined at %s", job.Name, v.location(prev.File, joinPath(prev.path, "name")))
+		} else {
+			names[job.Name] = job
+		}
+
+		if !skip[i] {
+			v.validateJob(job, cfg.Sink, cfg.templates, sharedOK)
+		}
+	}
+}
+
+// validateJob checks a single job. Its annotations are only checked against
+// the shared templates if those parse.
+func (v *validator) validateJob(job *JobConfig, sinks map[string]sink.SinkConfig, shared map[string]string, sharedOK bool) {
+	file, path := job.File, job.path
+
+	if job.Duration < MinDuration || job.Duration > MaxDuration {
+		v.addf(file, joinPath(path, "duration"), "duration must be between %d and %d seconds, got %d", MinDuration, MaxDuration, job.Duration)
+	}
+
+	switch job.Type {
+	case "batch", "stream":
+	default:
+		v.addf(file, joinPath(path, "type"), "unknown job type %q, must be batch or stream", job.Type)
+	}
+
+	for name, idx := range job.Labels {
+		if err := label.CheckIndex(idx); err != nil {
+			v.add(file, joinPath(joinPath(path, "labels"), name), err)
+		}
+	}
+
+	for name, src := range job.LabelExprs {
+		p := joinPath(joinPath(path, "labelExprs"), name)
+		if _, ok := job.Labels[name]; ok {
+			v.addf(file, p, "label %q is also defined in labels", name)
+		} else if err := label.CheckExpr(src); err != nil {
+			v.add(file, p, err)
+		}
+	}
+
+	for name, format := range job.LabelFormats {
+		p := joinPath(joinPath(path, "labelFormats"), name)
+		_, isPath := job.Labels[name]
+		_, isExpr := job.LabelExprs[name]
+		if !isPath && !isExpr {
+			v.addf(file, p, "label %q is not defined", name)
+		} else if err := format.Validate(); err != nil {
+			v.add(file, p, err)
+		}
+	}
+
+	switch job.FieldLookup {
+	case "", label.LookupNested, label.LookupFlattened:
+	default:
+		v.addf(file, joinPath(path, "fieldLookup"), "unknown field lookup %q, must be nested or flattened", job.FieldLookup)
+	}
+
+	switch job.TemplateMode {
+	case "", label.TemplateText, label.TemplateHTML:
+		if !sharedOK {
+			break
+		}
+		for name, tpl := range job.Annotations {
+			if err := label.CheckTemplate(name, tpl, job.TemplateMode, shared); err != nil {
+				v.add(file, joinPath(joinPath(path, "annotations"), name), err)
+			}
+		}
+	default:
+		v.addf(file, joinPath(path, "templateMode"), "unknown template mode %q, must be text or html", job.TemplateMode)
+	}
+
+	if err := source.Validate(job.SourceConfig); err != nil {
+		v.add(file, joinPath(path, "source"), err)
+	}
+
+	if _, err := logger.ParseLevel(job.LogLevel); err != nil {
+		v.add(file, joinPath(path, "logLevel"), err)
+	}
+
+	if len(job.Sink) == 0 {
+		v.addf(file, joinPath(path, "sink"), "at least one sink is required")
+	}
+	for i, name := range job.Sink {
+		if _, ok := sinks[name]; !ok {
+			v.addf(file, indexPath(joinPath(path, "sink"), i), "sink %q is not defined", name)
+		}
+	}
+}
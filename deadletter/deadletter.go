@@ -0,0 +1,134 @@
+package deadletter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/label"
+)
+
+// Entry is a message a sink gave up on.
+type Entry struct {
+	ID       string        `json:"id"`
+	Time     time.Time     `json:"time"`
+	Sink     string        `json:"sink"`
+	Attempts int           `json:"attempts"`
+	Error    string        `json:"error"`
+	Message  label.Message `json:"message"`
+}
+
+// Store keeps dead-lettered messages in a directory, one JSON file per
+// entry, so that they survive restarts and can be replayed.
+type Store struct {
+	dir string
+}
+
+// Open returns the store in dir, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "failed to open dead-letter store")
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Put saves e, assigning it an ID and time if unset.
+func (s *Store) Put(e Entry) (Entry, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.ID == "" {
+		e.ID = newID(e.Time)
+	}
+
+	raw, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return e, err
+	}
+
+	// write to a temp file first so a listing never sees half an entry
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return e, errors.Wrap(err, "failed to write dead letter")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return e, errors.Wrap(err, "failed to write dead letter")
+	}
+	if err := tmp.Close(); err != nil {
+		return e, errors.Wrap(err, "failed to write dead letter")
+	}
+	if err := os.Rename(tmp.Name(), s.path(e.ID)); err != nil {
+		return e, errors.Wrap(err, "failed to write dead letter")
+	}
+
+	return e, nil
+}
+
+// List returns every entry, oldest first.
+func (s *Store) List() ([]Entry, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(files))
+	for _, f := range files {
+		e, err := s.Get(strings.TrimSuffix(filepath.Base(f), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries, nil
+}
+
+func (s *Store) Get(id string) (Entry, error) {
+	var e Entry
+
+	raw, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return e, errors.Errorf("dead letter %s not found", id)
+	}
+	if err != nil {
+		return e, errors.Wrap(err, "failed to read dead letter")
+	}
+
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return e, errors.Wrapf(err, "invalid dead letter %s", id)
+	}
+	return e, nil
+}
+
+func (s *Store) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete dead letter")
+	}
+	return nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
+
+// newID returns an ID that sorts by time and does not collide between
+// workers.
+func newID(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s", t.UTC().Format("20060102T150405.000000"), hex.EncodeToString(b))
+}
@@ -0,0 +1,24 @@
+// Package plugin holds helpers shared by the source and sink plugins.
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Decode strictly unmarshals a raw plugin config, rejecting unknown keys.
+// field names the config section in errors, e.g. "sinkConfig".
+func Decode(raw json.RawMessage, v interface{}, field string) error {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.Wrapf(err, "invalid %s", field)
+	}
+	return nil
+}
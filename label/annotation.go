@@ -2,29 +2,178 @@ package label
 
 import (
 	"bytes"
-	"html/template"
-	"log/slog"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"text/template"
+	"time"
 )
 
-func BuildAnnotations(labels map[string]string, relabels map[string]string) map[string]string {
-	annotations := make(map[string]string)
+// Template modes. Text renders values as they are; HTML escapes them for
+// receivers that render annotations as HTML.
+const (
+	TemplateText = "text"
+	TemplateHTML = "html"
+)
+
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// base holds the shared template definitions every annotation template is
+// parsed on top of.
+type base interface {
+	add(name string, text string) (executor, error)
+}
+
+type textBase struct{ t *template.Template }
+
+func (b textBase) add(name string, text string) (executor, error) {
+	t, err := b.t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return t.New(name).Parse(text)
+}
+
+type htmlBase struct{ t *htmltemplate.Template }
+
+func (b htmlBase) add(name string, text string) (executor, error) {
+	t, err := b.t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return t.New(name).Parse(text)
+}
+
+// parseShared parses the shared template files, keyed by file name, in name
+// order.
+func parseShared(mode string, shared map[string]string) (base, error) {
+	names := make([]string, 0, len(shared))
+	for name := range shared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch mode {
+	case "", TemplateText:
+		t := template.New("").Funcs(Funcs)
+		for _, name := range names {
+			if _, err := t.New(name).Parse(shared[name]); err != nil {
+				return nil, fmt.Errorf("invalid template file %s: %w", name, err)
+			}
+		}
+		return textBase{t}, nil
+	case TemplateHTML:
+		t := htmltemplate.New("").Funcs(htmltemplate.FuncMap(Funcs))
+		for _, name := range names {
+			if _, err := t.New(name).Parse(shared[name]); err != nil {
+				return nil, fmt.Errorf("invalid template file %s: %w", name, err)
+			}
+		}
+		return htmlBase{t}, nil
+	default:
+		return nil, fmt.Errorf("unknown template mode %q, must be text or html", mode)
+	}
+}
+
+// Templates are the parsed annotation templates of a job.
+type Templates struct {
+	tpls map[string]executor
+}
 
-	for k, tpl := range relabels {
-		t := template.New(k)
-		parse, err := t.Parse(tpl)
+// NewTemplates parses every annotation template, each of which may use the
+// templates defined with {{ define }} in the shared template files. Parse
+// failures are returned together as a single joined error.
+func NewTemplates(annotations map[string]string, mode string, shared map[string]string) (*Templates, error) {
+	b, err := parseShared(mode, shared)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{tpls: make(map[string]executor, len(annotations))}
+
+	var errs []error
+	for k, tpl := range annotations {
+		parsed, err := b.add(k, tpl)
 		if err != nil {
-			slog.Error("invalid template", "name", k, "template", tpl, "error", err)
+			errs = append(errs, fmt.Errorf("invalid template %s: %w", k, err))
 			continue
 		}
+		t.tpls[k] = parsed
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return t, nil
+}
+
+// Execute renders every annotation template against data. Annotations whose
+// template fails to execute are left out and the failures are returned
+// together as a single joined error.
+func (t *Templates) Execute(data interface{}) (map[string]string, error) {
+	annotations := make(map[string]string)
+
+	var errs []error
+	for k, tpl := range t.tpls {
 		bf := bytes.NewBufferString("")
-		err = parse.Execute(bf, &labels)
+		err := tpl.Execute(bf, data)
 		if err != nil {
-			slog.Error("failed to execute template", "name", k, "template", tpl, "error", err)
+			errs = append(errs, fmt.Errorf("failed to execute template %s: %w", k, err))
 			continue
 		} else {
 			annotations[k] = bf.String()
 		}
 	}
 
-	return annotations
+	return annotations, errors.Join(errs...)
+}
+
+// CheckShared reports whether the shared template files parse.
+func CheckShared(shared map[string]string) error {
+	_, err := parseShared(TemplateText, shared)
+	return err
+}
+
+// CheckTemplate reports whether tpl parses as an annotation template on top
+// of the shared template files.
+func CheckTemplate(name string, tpl string, mode string, shared map[string]string) error {
+	b, err := parseShared(mode, shared)
+	if err != nil {
+		return err
+	}
+	_, err = b.add(name, tpl)
+	return err
+}
+
+// Window is the time range a job fetched a document from.
+type Window struct {
+	From time.Time
+	Now  time.Time
+}
+
+// TemplateData is what annotation templates are executed against: the
+// labels at the top level, so that {{ .host }} keeps working, along with
+// .Labels, .Doc, the source document, .Job and .Window. These names take
+// precedence over labels of the same name. names are the labels the job
+// defines; those missing from labels are set to "" at the top level rather
+// than printing as <no value>.
+func TemplateData(labels map[string]string, names []string, doc map[string]interface{}, job string, window Window) map[string]interface{} {
+	data := make(map[string]interface{}, len(labels)+4)
+	for _, k := range names {
+		data[k] = ""
+	}
+	for k, v := range labels {
+		data[k] = v
+	}
+
+	data["Labels"] = labels
+	data["Doc"] = doc
+	data["Job"] = job
+	data["Window"] = window
+
+	return data
 }
@@ -0,0 +1,104 @@
+package label
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplatesExecute(t *testing.T) {
+	labels := map[string]string{
+		"message": `disk <sda> & "sdb" full`,
+		"bytes":   "1234567",
+		"elapsed": "93784",
+		"time":    "2024-03-01T12:30:00Z",
+		"tags":    `["a","b"]`,
+		"empty":   "",
+		"raw":     "<no value>",
+	}
+	doc := map[string]interface{}{"host": map[string]interface{}{"name": "web-1"}}
+	window := Window{From: time.Unix(1709292600, 0), Now: time.Unix(1709296200, 0)}
+	shared := map[string]string{
+		"common.tmpl": `{{ define "host" }}{{ .host | default "unknown host" }}{{ end }}`,
+	}
+
+	tests := []struct {
+		name string
+		tpl  string
+		mode string
+		want string
+	}{
+		{name: "Text is not escaped", tpl: "{{ .message }}", want: `disk <sda> & "sdb" full`},
+		{name: "HTML is escaped", tpl: "{{ .message }}", mode: TemplateHTML, want: "disk &lt;sda&gt; &amp; &#34;sdb&#34; full"},
+		{name: "Upper", tpl: "{{ .message | upper }}", want: `DISK <SDA> & "SDB" FULL`},
+		{name: "Trunc", tpl: "{{ .message | trunc 4 }}", want: "disk"},
+		{name: "Replace", tpl: `{{ .message | replace "full" "empty" }}`, want: `disk <sda> & "sdb" empty`},
+		{name: "Regex replace", tpl: `{{ .message | regexReplace "<(\\w+)>" "$1" }}`, want: `disk sda & "sdb" full`},
+		{name: "Default", tpl: `{{ .empty | default "none" }} {{ .missing | default "none" }}`, want: "none none"},
+		{name: "Missing label", tpl: `[{{ .missing }}]`, want: "[]"},
+		{name: "Missing label in HTML", tpl: `[{{ .missing }}]`, mode: TemplateHTML, want: "[]"},
+		{name: "Value looking like a missing key", tpl: `{{ .raw }}`, want: "<no value>"},
+		{name: "Context", tpl: `{{ .Job }} {{ .Labels.bytes }} {{ .Doc.host.name }} {{ .Window.Now.Unix }}`, want: "job1 1234567 web-1 1709296200"},
+		{name: "JSON", tpl: `{{ .tags | fromJson | join "," }} {{ .message | toJson }}`, want: `a,b "disk <sda> & \"sdb\" full"`},
+		{name: "Time", tpl: `{{ .time | formatTime "02 Jan 15:04" }} {{ (parseTime "2006-01-02" "2024-03-01").Unix }}`, want: "01 Mar 12:30 1709251200"},
+		{name: "Humanize", tpl: "{{ .bytes | humanize }} {{ .elapsed | humanizeDuration }}", want: "1.235M 1d 2h 3m 4s"},
+		{name: "Shared template", tpl: `{{ template "host" . }}`, want: "unknown host"},
+		{name: "Query escape", tpl: "{{ .message | queryEscape }}", want: "disk+%3Csda%3E+%26+%22sdb%22+full"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tpls, err := NewTemplates(map[string]string{"a": test.tpl}, test.mode, shared)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tpls.Execute(TemplateData(labels, []string{"message", "missing"}, doc, "job1", window))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["a"] != test.want {
+				t.Errorf("Unexpected result. Got: %v, Want: %v", got["a"], test.want)
+			}
+		})
+	}
+}
+
+func TestNewTemplatesErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		shared      map[string]string
+		err         string
+	}{
+		{name: "Bad annotation", annotations: map[string]string{"a": "{{ .x"}, err: "invalid template a"},
+		{name: "Unknown function", annotations: map[string]string{"a": "{{ .x | nope }}"}, err: `function "nope" not defined`},
+		{name: "Bad shared file", shared: map[string]string{"common.tmpl": "{{ define }}"}, err: "invalid template file common.tmpl"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewTemplates(test.annotations, TemplateText, test.shared)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Unexpected result. Got: %v, Want: %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRegexReplaceCache(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		got, err := regexReplace(`web-(\d+)`, "host $1", "web-12")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "host 12" {
+			t.Errorf("Unexpected result. Got: %v, Want: %v", got, "host 12")
+		}
+	}
+	if _, ok := regexps.Load(`web-(\d+)`); !ok {
+		t.Errorf("Pattern was not cached")
+	}
+	if _, err := regexReplace(`(`, "", "x"); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+}
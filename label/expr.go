@@ -0,0 +1,91 @@
+package label
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/vm"
+)
+
+// compileExpr compiles a label expression. Expressions see the fields of
+// the source document as variables; fields missing from a document are
+// nil rather than an error. Documents have no schema, so fields are typed
+// per document: service + 1 compiles, and fails on the documents where
+// service is a string. What is checked here is the syntax, the types of
+// literals and builtins ("a" + 1, len(1), upper(1)), that every function
+// called exists and that the result can be a label value.
+func compileExpr(src string) (*vm.Program, error) {
+	p, err := expr.Compile(src, expr.Env(map[string]interface{}{}), expr.AllowUndefinedVariables())
+	if err != nil {
+		return nil, err
+	}
+
+	node := p.Node()
+	c := &exprChecker{}
+	ast.Walk(&node, c)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	switch t := node.Type(); {
+	case t == nil:
+	case t.Kind() == reflect.Map, t.Kind() == reflect.Slice, t.Kind() == reflect.Func:
+		return nil, fmt.Errorf("expression returns a %s, not a label value", t)
+	}
+
+	return p, nil
+}
+
+// exprChecker rejects calls to functions that don't exist. The environment
+// has no functions, so any call by name is a typo for a builtin.
+type exprChecker struct {
+	err error
+}
+
+func (c *exprChecker) Visit(node *ast.Node) {
+	call, ok := (*node).(*ast.CallNode)
+	if !ok || c.err != nil {
+		return
+	}
+	if id, ok := call.Callee.(*ast.IdentifierNode); ok {
+		c.err = fmt.Errorf("unknown function %s", id.Value)
+	}
+}
+
+// CheckExpr reports whether src compiles as a label expression. See
+// compileExpr for what it does and does not catch.
+func CheckExpr(src string) error {
+	_, err := compileExpr(src)
+	return err
+}
+
+// SetExprs sets labels computed by expressions over the source document,
+// such as status >= 500 ? "critical" : "warning". They are extracted like
+// path labels; an expression that fails on a document leaves its label
+// missing and makes Extract return the error.
+func (f *FieldExtractor) SetExprs(exprs map[string]string) error {
+	programs := make(map[string]*vm.Program, len(exprs))
+	for k, src := range exprs {
+		p, err := compileExpr(src)
+		if err != nil {
+			return fmt.Errorf("label %s: %w", k, err)
+		}
+		programs[k] = p
+	}
+	f.exprs = programs
+
+	return nil
+}
+
+func evalExpr(p *vm.Program, input map[string]interface{}) (interface{}, error) {
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	val, err := expr.Run(p, input)
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
+}
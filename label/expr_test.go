@@ -0,0 +1,70 @@
+package label
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractExprs(t *testing.T) {
+	f := NewFieldExtractor(map[string]string{"host": "host.name"})
+	err := f.SetExprs(map[string]string{
+		"severity": `http.status >= 500 ? "critical" : "warning"`,
+		"service":  `service + "/" + env`,
+		"user":     `user?.name ?? "unknown"`,
+		"broken":   `service + 1`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := map[string]interface{}{
+		"host":    map[string]interface{}{"name": "web-1"},
+		"http":    map[string]interface{}{"status": float64(503)},
+		"service": "api",
+		"env":     "prod",
+	}
+	expected := map[string]string{
+		"host":     "web-1",
+		"severity": "critical",
+		"service":  "api/prod",
+		"user":     "unknown",
+		"broken":   "",
+	}
+	values, err := f.Extract(input)
+	if err == nil || !strings.Contains(err.Error(), "label broken") {
+		t.Errorf("Unexpected error. Got: %v, Want: an error for label broken", err)
+	}
+	if result := f.Render(values); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Unexpected result. Got: %v, Want: %v", result, expected)
+	}
+	if missing := Missing(values); !reflect.DeepEqual(missing, []string{"broken"}) {
+		t.Errorf("Unexpected missing labels. Got: %v", missing)
+	}
+}
+
+func TestCheckExpr(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		valid bool
+	}{
+		{name: "Valid", src: `status >= 500 ? "critical" : "warning"`, valid: true},
+		{name: "Syntax error", src: `status >=`},
+		{name: "Field of any type", src: `service + 1`, valid: true},
+		{name: "Type error", src: `1 + "a"`},
+		{name: "Builtin type error", src: `upper(1)`},
+		{name: "Unknown function", src: `uper(service)`},
+		{name: "Map result", src: `{"service": service}`},
+		{name: "List result", src: `[service, env]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckExpr(test.src)
+			if (err == nil) != test.valid {
+				t.Errorf("Unexpected result. Got: %v, Want valid: %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package label
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+
+	"github.com/expr-lang/expr/vm"
 )
 
 const (
@@ -23,6 +28,11 @@ func AppendIndex(i Index, chars ...string) Index {
 	return i
 }
 
+// ParseIndex splits a field path into its keys. Keys are separated by dots
+// and may be quoted to contain dots. Array selectors in brackets become
+// their own elements: a numeric index, negative from the end, [*] for every
+// element, or a predicate like [?type=="ipv4"] for the elements whose field
+// has that value.
 func ParseIndex(raw string) Index {
 	if raw == "" {
 		panic("empty index")
@@ -30,93 +40,446 @@ func ParseIndex(raw string) Index {
 
 	index := Index{}
 
-	for {
-		if raw == "" {
-			break
-		}
-
-		s := strings.SplitN(raw, escapeChar, 3)
-
-		switch len(s) {
-		case 0:
-			return index
-		case 1:
-			index = AppendIndex(index, strings.Split(s[0], delimiterChar)...)
-			return index
-		case 2:
-			index = AppendIndex(index, strings.Split(s[0], delimiterChar)...)
-			index = AppendIndex(index, s[1])
-			return index
-		case 3:
-			index = AppendIndex(index, strings.Split(s[0], delimiterChar)...)
-			index = AppendIndex(index, s[1])
-			raw = s[2]
+	var key strings.Builder
+	flush := func() {
+		index = AppendIndex(index, key.String())
+		key.Reset()
+	}
+
+	for i := 0; i < len(raw); i++ {
+		switch c := raw[i]; c {
+		case escapeChar[0]:
+			flush()
+			end := strings.Index(raw[i+1:], escapeChar)
+			if end < 0 {
+				end = len(raw) - i - 1
+			}
+			index = AppendIndex(index, raw[i+1:i+1+end])
+			i += end + 1
+		case delimiterChar[0]:
+			flush()
+		case '[':
+			flush()
+			end := selectorEnd(raw, i)
+			index = append(index, raw[i:end])
+			i = end - 1
+		default:
+			key.WriteByte(c)
+		}
+	}
+	flush()
+
+	return index
+}
+
+// selectorEnd returns the position after the bracket closing the selector
+// opened at start, or the end of raw if it is not closed.
+func selectorEnd(raw string, start int) int {
+	depth := 0
+	quote := byte(0)
+	for i := start; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(raw)
+}
+
+// CheckIndex reports whether raw is a usable field path. ParseIndex panics
+// on an empty path and silently accepts unbalanced quotes.
+func CheckIndex(raw string) error {
+	if raw == "" {
+		return errors.New("empty field path")
+	}
+	if strings.Count(raw, escapeChar)%2 != 0 {
+		return fmt.Errorf("unbalanced %s in field path %s", escapeChar, raw)
+	}
+
+	index := ParseIndex(raw)
+	if len(index) == 0 {
+		return fmt.Errorf("field path %s has no keys", raw)
+	}
+	if _, err := compileIndex(index); err != nil {
+		return fmt.Errorf("field path %s: %w", raw, err)
+	}
+	return nil
+}
+
+// step is a key of a compiled field path, or an array selector.
+type step struct {
+	key string
+	sel *selector
+}
+
+// path is an Index with its selectors parsed, so that lookups don't parse
+// them again.
+type path []step
+
+func compileIndex(i Index) (path, error) {
+	p := make(path, 0, len(i))
+	for _, key := range i {
+		if !strings.HasPrefix(key, "[") {
+			p = append(p, step{key: key})
 			continue
 		}
+		sel, err := parseSelector(key)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, step{sel: &sel})
+	}
+	return p, nil
+}
+
+// selector is a parsed array selector.
+type selector struct {
+	all   bool
+	index int
+
+	// filter, if set, selects the elements it matches
+	filter *predicate
+}
+
+type predicate struct {
+	field path
+	equal bool
+	value string
+}
+
+// match reports whether the field of elem compares to the predicate value.
+// The field @ is elem itself.
+func (p *predicate) match(elem interface{}, flat bool) bool {
+	val := elem
+	if len(p.field) != 1 || p.field[0].key != "@" {
+		val = find(p.field, elem, flat)
+	}
+	if val == nil {
+		return !p.equal
+	}
+	return (fmt.Sprint(val) == p.value) == p.equal
+}
+
+func parseSelector(key string) (selector, error) {
+	if len(key) < 2 || !strings.HasSuffix(key, "]") {
+		return selector{}, fmt.Errorf("unbalanced [ in %s", key)
+	}
+	inner := strings.TrimSpace(key[1 : len(key)-1])
+
+	switch {
+	case inner == "*":
+		return selector{all: true}, nil
+	case strings.HasPrefix(inner, "?"):
+		p, err := parsePredicate(strings.TrimSpace(inner[1:]))
+		if err != nil {
+			return selector{}, fmt.Errorf("invalid filter %s: %w", key, err)
+		}
+		return selector{all: true, filter: p}, nil
+	default:
+		n, err := strconv.Atoi(inner)
+		if err != nil {
+			return selector{}, fmt.Errorf("invalid array index %s", key)
+		}
+		return selector{index: n}, nil
+	}
+}
+
+func parsePredicate(s string) (*predicate, error) {
+	p := &predicate{}
+
+	i := strings.Index(s, "==")
+	p.equal = true
+	if j := strings.Index(s, "!="); j >= 0 && (i < 0 || j < i) {
+		i, p.equal = j, false
+	}
+	if i < 0 {
+		return nil, errors.New("want field==value or field!=value")
+	}
 
+	field := strings.TrimSpace(s[:i])
+	if err := CheckIndex(field); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.field, err = compileIndex(ParseIndex(field)); err != nil {
+		return nil, err
 	}
 
-	return index
+	p.value = strings.TrimSpace(s[i+2:])
+	if len(p.value) >= 2 && (p.value[0] == '"' || p.value[0] == '\'') && p.value[len(p.value)-1] == p.value[0] {
+		p.value = p.value[1 : len(p.value)-1]
+	}
+
+	return p, nil
 }
 
+// FindMap returns the value at i in input, or nil if there is none. Paths
+// through [*] or a filter return every value found, as a []interface{}.
 func FindMap(i Index, input map[string]interface{}) interface{} {
-	if len(i) == 0 || input == nil {
+	return lookup(i, input, false)
+}
+
+// FindFlattened is FindMap for documents that mix nested objects with
+// literal dotted keys, like {"host.name": "x"}. Consecutive keys of i are
+// matched against dotted keys too, the longest match first, so a.b.c finds
+// {"a.b.c": x}, {"a.b": {"c": x}} and {"a": {"b": {"c": x}}} alike.
+func FindFlattened(i Index, input map[string]interface{}) interface{} {
+	return lookup(i, input, true)
+}
+
+func lookup(i Index, input map[string]interface{}, flat bool) interface{} {
+	p, err := compileIndex(i)
+	if err != nil {
 		return nil
 	}
+	return findPath(p, input, flat)
+}
 
-	val := input[i[0]]
+// findPath is find from the top of a document.
+func findPath(p path, input map[string]interface{}, flat bool) interface{} {
+	if len(p) == 0 || input == nil {
+		return nil
+	}
+
+	return find(p, input, flat)
+}
 
-	// last index
-	if len(i) == 1 {
+func find(p path, val interface{}, flat bool) interface{} {
+	if len(p) == 0 {
 		return val
 	}
 
-	m, ok := val.(map[string]interface{})
+	if p[0].sel == nil {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if !flat {
+			return find(p[1:], m[p[0].key], false)
+		}
+
+		n := 1
+		for n < len(p) && p[n].sel == nil {
+			n++
+		}
+		for ; n > 0; n-- {
+			keys := make([]string, n)
+			for j := range keys {
+				keys[j] = p[j].key
+			}
+			v, ok := m[strings.Join(keys, delimiterChar)]
+			if !ok {
+				continue
+			}
+			if found := find(p[n:], v, true); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
 
+	list, ok := val.([]interface{})
 	if !ok {
-		// return val
 		return nil
 	}
+	sel := p[0].sel
 
-	return FindMap(i[1:], m)
+	if !sel.all {
+		n := sel.index
+		if n < 0 {
+			n += len(list)
+		}
+		if n < 0 || n >= len(list) {
+			return nil
+		}
+		return find(p[1:], list[n], flat)
+	}
+
+	var found []interface{}
+	for _, elem := range list {
+		if sel.filter != nil && !sel.filter.match(elem, flat) {
+			continue
+		}
+		switch v := find(p[1:], elem, flat).(type) {
+		case nil:
+		case []interface{}:
+			found = append(found, v...)
+		default:
+			found = append(found, v)
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return found
 }
 
 // func FindStruct(i Index, input interface{}) interface{} {
 // 	return nil
 // }
 
+// DefaultSeparator joins the values of multi-valued fields.
+const DefaultSeparator = ","
+
+// Field lookup modes. Nested matches every key of a path one level down;
+// flattened also matches literal dotted keys, see FindFlattened.
+const (
+	LookupNested    = "nested"
+	LookupFlattened = "flattened"
+)
+
 type FieldExtractor struct {
-	mappings map[string]Index
+	// mappings are the label paths, compiled; a path whose selectors don't
+	// parse is empty and never matches
+	mappings map[string]path
+
+	// Separator joins the values of multi-valued fields in Render.
+	Separator string
+
+	// Flattened looks fields up with FindFlattened instead of FindMap.
+	Flattened bool
+
+	formats map[string]formatter
+	exprs   map[string]*vm.Program
+}
+
+// SetFormats sets how the values of labels are rendered by Render.
+// Labels without a format render values as they are.
+func (f *FieldExtractor) SetFormats(formats map[string]Format) error {
+	compiled := make(map[string]formatter, len(formats))
+	for k, v := range formats {
+		c, err := newFormatter(v)
+		if err != nil {
+			return fmt.Errorf("label %s: %w", k, err)
+		}
+		compiled[k] = c
+	}
+	f.formats = compiled
+
+	return nil
+}
+
+func (f *FieldExtractor) find(p path, input map[string]interface{}) interface{} {
+	return findPath(p, input, f.Flattened)
 }
 
-func (f *FieldExtractor) Extract(input map[string]interface{}) map[string]interface{} {
+// Extract returns the value of every label in input, nil for the missing
+// ones. A label expression that fails on input leaves its label missing;
+// the failures are returned joined.
+func (f *FieldExtractor) Extract(input map[string]interface{}) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
 
 	for k, v := range f.mappings {
-		result[k] = FindMap(v, input)
+		result[k] = f.find(v, input)
 	}
 
-	return result
+	var errs []error
+	for k, p := range f.exprs {
+		val, err := evalExpr(p, input)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("label %s: %w", k, err))
+		}
+		result[k] = val
+	}
+
+	return result, errors.Join(errs...)
 }
 
-func (f *FieldExtractor) ExtractString(input map[string]interface{}) map[string]string {
+// Render renders the values Extract returned as label values, applying
+// their formats.
+func (f *FieldExtractor) Render(values map[string]interface{}) map[string]string {
 	result := make(map[string]string)
 
-	for k, v := range f.mappings {
-		result[k] = fmt.Sprintf("%v", FindMap(v, input))
+	for k, val := range values {
+		format, ok := f.formats[k]
+		if !ok {
+			result[k] = f.format(val)
+			continue
+		}
+		if s, ok := format.render(val, f.Separator); ok {
+			result[k] = s
+		}
 	}
 
 	return result
 }
 
+// ExtractString is Extract followed by Render, for callers that don't need
+// to know about failed expressions.
+func (f *FieldExtractor) ExtractString(input map[string]interface{}) map[string]string {
+	values, _ := f.Extract(input)
+	return f.Render(values)
+}
+
+// format renders the value of an unformatted label. A missing field
+// renders empty.
+func (f *FieldExtractor) format(val interface{}) string {
+	if val == nil {
+		return ""
+	}
+	list, ok := val.([]interface{})
+	if !ok {
+		return fmt.Sprintf("%v", val)
+	}
+
+	parts := make([]string, len(list))
+	for i, v := range list {
+		parts[i] = fmt.Sprintf("%v", v)
+	}
+	return strings.Join(parts, f.Separator)
+}
+
+// Names returns the labels f extracts, sorted.
+func (f *FieldExtractor) Names() []string {
+	names := make([]string, 0, len(f.mappings)+len(f.exprs))
+	for k := range f.mappings {
+		names = append(names, k)
+	}
+	for k := range f.exprs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Missing returns the labels of values, as returned by Extract, whose
+// field is missing, sorted.
+func Missing(values map[string]interface{}) []string {
+	var missing []string
+	for k, v := range values {
+		if v == nil {
+			missing = append(missing, k)
+		}
+	}
+	sort.Strings(missing)
+
+	return missing
+}
+
 func NewFieldExtractor(mappings map[string]string) *FieldExtractor {
-	m := make(map[string]Index)
+	m := make(map[string]path)
 
 	for k, v := range mappings {
-		m[k] = ParseIndex(v)
+		// the config is checked with CheckIndex, so this only drops paths
+		// of extractors built without it
+		m[k], _ = compileIndex(ParseIndex(v))
 	}
 
 	return &FieldExtractor{
-		mappings: m,
+		mappings:  m,
+		Separator: DefaultSeparator,
 	}
 }
@@ -2,6 +2,7 @@ package label
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -46,6 +47,21 @@ func TestParseIndex(t *testing.T) {
 			raw:      "\"value1.value2\".value3.\"value4.value5\".value6.\"value7.value8\"",
 			expected: Index{"value1.value2", "value3", "value4.value5", "value6", "value7.value8"},
 		},
+		{
+			name:     "Array index",
+			raw:      "related.ip[0]",
+			expected: Index{"related", "ip", "[0]"},
+		},
+		{
+			name:     "Wildcard followed by key",
+			raw:      "threat.indicator[*].ip",
+			expected: Index{"threat", "indicator", "[*]", "ip"},
+		},
+		{
+			name:     "Filter with quoted value",
+			raw:      "\"host.ip\"[?type==\"ipv4.x\"].value",
+			expected: Index{"host.ip", "[?type==\"ipv4.x\"]", "value"},
+		},
 	}
 
 	for _, test := range tests {
@@ -185,7 +201,120 @@ func TestFieldExtractor_Extract(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			f := NewFieldExtractor(test.mappings)
-			result := f.Extract(test.input)
+			result, err := f.Extract(test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Unexpected result. Got: %v, Want: %v", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestFindMapArrays(t *testing.T) {
+	input := map[string]interface{}{
+		"tags":    []interface{}{"a", "b", "c"},
+		"tags[0]": "literal",
+		"threat": map[string]interface{}{
+			"indicator": []interface{}{
+				map[string]interface{}{"type": "ipv4", "ip": "10.0.0.1"},
+				map[string]interface{}{"type": "ipv6", "ip": "::1"},
+				map[string]interface{}{"type": "ipv4", "ip": "10.0.0.2"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected interface{}
+	}{
+		{name: "Index", raw: "tags[1]", expected: "b"},
+		{name: "Negative index", raw: "tags[-1]", expected: "c"},
+		{name: "Index out of range", raw: "tags[3]", expected: nil},
+		{name: "Index on a map", raw: "threat[0]", expected: nil},
+		{name: "Wildcard", raw: "threat.indicator[*].ip", expected: []interface{}{"10.0.0.1", "::1", "10.0.0.2"}},
+		{name: "Filter", raw: `threat.indicator[?type=="ipv4"].ip`, expected: []interface{}{"10.0.0.1", "10.0.0.2"}},
+		{name: "Negated filter", raw: `threat.indicator[?type!='ipv4'].ip`, expected: []interface{}{"::1"}},
+		{name: "Filter on the element", raw: `tags[?@=="b"]`, expected: []interface{}{"b"}},
+		{name: "Filter without match", raw: `threat.indicator[?type=="dns"].ip`, expected: nil},
+		{name: "Quoted key with a bracket", raw: `"tags[0]"`, expected: "literal"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := CheckIndex(test.raw); err != nil {
+				t.Fatal(err)
+			}
+			result := FindMap(ParseIndex(test.raw), input)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Unexpected result. Got: %v, Want: %v", result, test.expected)
+			}
+		})
+	}
+
+	f := NewFieldExtractor(map[string]string{"ips": "threat.indicator[*].ip", "tags": "tags", "bad": "tags[x]"})
+	f.Separator = ";"
+	if sel := f.mappings["ips"][2].sel; sel == nil || !sel.all {
+		t.Errorf("Selector was not compiled. Got: %+v", f.mappings["ips"])
+	}
+	expected := map[string]string{"ips": "10.0.0.1;::1;10.0.0.2", "tags": "a;b;c", "bad": ""}
+	if result := f.ExtractString(input); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Unexpected result. Got: %v, Want: %v", result, expected)
+	}
+}
+
+func TestCheckIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{name: "Valid", raw: `a.b[0][*][?c=="d"]`},
+		{name: "Unbalanced quote", raw: `a."b`, err: "unbalanced"},
+		{name: "Unbalanced bracket", raw: "a[0", err: "unbalanced ["},
+		{name: "Bad index", raw: "a[x]", err: "invalid array index"},
+		{name: "Bad filter", raw: "a[?b]", err: "invalid filter"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckIndex(test.raw)
+			if (err == nil) != (test.err == "") || (err != nil && !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("Unexpected result. Got: %v, Want: %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestFindFlattened(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		input    map[string]interface{}
+		expected interface{}
+	}{
+		{name: "Nested", raw: "host.name", input: map[string]interface{}{"host": map[string]interface{}{"name": "x"}}, expected: "x"},
+		{name: "Flattened", raw: "host.name", input: map[string]interface{}{"host.name": "x"}, expected: "x"},
+		{name: "Mixed", raw: "host.os.name", input: map[string]interface{}{"host": map[string]interface{}{"os.name": "x"}}, expected: "x"},
+		{name: "Longest prefix first", raw: "host.os.name", input: map[string]interface{}{
+			"host.os": map[string]interface{}{"name": "long"},
+			"host":    map[string]interface{}{"os": map[string]interface{}{"name": "short"}},
+		}, expected: "long"},
+		{name: "Falls back to shorter prefix", raw: "host.os.name", input: map[string]interface{}{
+			"host.os": map[string]interface{}{"version": "1"},
+			"host":    map[string]interface{}{"os": map[string]interface{}{"name": "short"}},
+		}, expected: "short"},
+		{name: "Through an array", raw: "related.hosts[0].host.name", input: map[string]interface{}{
+			"related.hosts": []interface{}{map[string]interface{}{"host.name": "x"}},
+		}, expected: "x"},
+		{name: "Missing", raw: "host.name", input: map[string]interface{}{"host": "x"}, expected: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := FindFlattened(ParseIndex(test.raw), test.input)
 			if !reflect.DeepEqual(result, test.expected) {
 				t.Errorf("Unexpected result. Got: %v, Want: %v", result, test.expected)
 			}
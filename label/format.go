@@ -0,0 +1,215 @@
+package label
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Label value types.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeJSON   = "json"
+	TypeTime   = "time"
+)
+
+// Format sets how the value of a label is rendered.
+type Format struct {
+	// Type is one of string, the default, int, float, json or time.
+	// Multi-valued fields are converted value by value, except as json.
+	// Numbers with a fraction can't be converted to int.
+	Type string `json:"type"`
+
+	// Default is used when the field is missing or cannot be converted.
+	Default *string `json:"default"`
+
+	// OmitMissing leaves the label out when the field is missing.
+	OmitMissing bool `json:"omitMissing"`
+
+	// Precision is the number of decimals of a float, shortest if unset.
+	Precision *int `json:"precision"`
+
+	// Layout is the Go layout times are rendered in, RFC 3339 by default.
+	Layout string `json:"layout"`
+
+	// InputLayout is the Go layout times are parsed with, or unix or
+	// unix_ms for epoch seconds or milliseconds. By default RFC 3339
+	// strings and epoch seconds are accepted.
+	InputLayout string `json:"inputLayout"`
+
+	// TimeZone is the IANA time zone times are rendered in, UTC by
+	// default.
+	TimeZone string `json:"timezone"`
+}
+
+func (f Format) Validate() error {
+	switch f.Type {
+	case "", TypeString, TypeInt, TypeFloat, TypeJSON, TypeTime:
+	default:
+		return fmt.Errorf("unknown label type %q, must be string, int, float, json or time", f.Type)
+	}
+
+	if f.OmitMissing && f.Default != nil {
+		return errors.New("default and omitMissing cannot both be set")
+	}
+	if f.Precision != nil && *f.Precision < 0 {
+		return fmt.Errorf("precision must not be negative, got %d", *f.Precision)
+	}
+	if _, err := time.LoadLocation(f.TimeZone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", f.TimeZone, err)
+	}
+	return nil
+}
+
+// formatter is a validated Format.
+type formatter struct {
+	Format
+	loc *time.Location
+}
+
+func newFormatter(f Format) (formatter, error) {
+	if err := f.Validate(); err != nil {
+		return formatter{}, err
+	}
+	loc, err := time.LoadLocation(f.TimeZone)
+	if err != nil {
+		return formatter{}, err
+	}
+	return formatter{Format: f, loc: loc}, nil
+}
+
+// render returns the label value for val, and false if the label is to be
+// left out.
+func (f formatter) render(val interface{}, sep string) (string, bool) {
+	if val == nil {
+		if f.OmitMissing {
+			return "", false
+		}
+		if f.Default != nil {
+			return *f.Default, true
+		}
+		return "", true
+	}
+
+	s, err := f.convert(val, sep)
+	if err != nil {
+		if f.Default != nil {
+			return *f.Default, true
+		}
+		return fmt.Sprintf("%v", val), true
+	}
+	return s, true
+}
+
+func (f formatter) convert(val interface{}, sep string) (string, error) {
+	if f.Type == TypeJSON {
+		return toJSON(val)
+	}
+
+	list, ok := val.([]interface{})
+	if !ok {
+		return f.convertOne(val)
+	}
+
+	parts := make([]string, len(list))
+	for i, v := range list {
+		s, err := f.convertOne(v)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func (f formatter) convertOne(val interface{}) (string, error) {
+	switch f.Type {
+	case TypeInt:
+		return toInt(val)
+	case TypeFloat:
+		n, err := toFloat(val)
+		if err != nil {
+			return "", err
+		}
+		prec := -1
+		if f.Precision != nil {
+			prec = *f.Precision
+		}
+		return strconv.FormatFloat(n, 'f', prec, 64), nil
+	case TypeTime:
+		t, err := parseTimeValue(val, f.InputLayout)
+		if err != nil {
+			return "", err
+		}
+		layout := f.Layout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return t.In(f.loc).Format(layout), nil
+	default:
+		return fmt.Sprintf("%v", val), nil
+	}
+}
+
+// toInt renders val as an integer. Strings are parsed as integers first so
+// that values past 2^53 keep their precision; floats with a fraction are
+// rejected rather than rounded.
+func toInt(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return strconv.FormatInt(n, 10), nil
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return strconv.FormatInt(n, 10), nil
+		}
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	}
+
+	f, err := toFloat(val)
+	if err != nil {
+		return "", err
+	}
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return "", fmt.Errorf("%v is not an integer", val)
+	}
+	return strconv.FormatInt(int64(f), 10), nil
+}
+
+func parseTimeValue(val interface{}, layout string) (time.Time, error) {
+	switch layout {
+	case "":
+		return toTime(val)
+	case "unix", "unix_ms":
+		n, err := toFloat(val)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if layout == "unix_ms" {
+			return time.UnixMilli(int64(n)), nil
+		}
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	default:
+		s, ok := val.(string)
+		if !ok {
+			return time.Time{}, fmt.Errorf("cannot parse %v as a time", val)
+		}
+		return time.Parse(layout, s)
+	}
+}
@@ -0,0 +1,90 @@
+package label
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractStringFormats(t *testing.T) {
+	none := "none"
+	two := 2
+
+	input := map[string]interface{}{
+		"bytes":   float64(1234567),
+		"ratio":   0.123456,
+		"ports":   []interface{}{float64(80), float64(443)},
+		"host":    map[string]interface{}{"name": "web-1"},
+		"created": "2024-03-01T12:30:00Z",
+		"epoch":   float64(1709296200000),
+		"status":  "ok",
+		"big":     "9007199254740993",
+		"number":  json.Number("9007199254740993"),
+		"count":   int64(9007199254740993),
+		"partial": 1.7,
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		format   *Format
+		expected map[string]string
+	}{
+		{name: "Unformatted number", path: "bytes", expected: map[string]string{"v": "1.234567e+06"}},
+		{name: "Int", path: "bytes", format: &Format{Type: TypeInt}, expected: map[string]string{"v": "1234567"}},
+		{name: "Int past 2^53", path: "big", format: &Format{Type: TypeInt}, expected: map[string]string{"v": "9007199254740993"}},
+		{name: "Int from a JSON number", path: "number", format: &Format{Type: TypeInt}, expected: map[string]string{"v": "9007199254740993"}},
+		{name: "Int from an int", path: "count", format: &Format{Type: TypeInt}, expected: map[string]string{"v": "9007199254740993"}},
+		{name: "Int from a fraction is not rounded", path: "partial", format: &Format{Type: TypeInt, Default: &none}, expected: map[string]string{"v": "none"}},
+		{name: "Unformatted missing", path: "nope", expected: map[string]string{"v": ""}},
+		{name: "Int list", path: "ports", format: &Format{Type: TypeInt}, expected: map[string]string{"v": "80,443"}},
+		{name: "Float precision", path: "ratio", format: &Format{Type: TypeFloat, Precision: &two}, expected: map[string]string{"v": "0.12"}},
+		{name: "JSON object", path: "host", format: &Format{Type: TypeJSON}, expected: map[string]string{"v": `{"name":"web-1"}`}},
+		{name: "Time in zone", path: "created", format: &Format{Type: TypeTime, Layout: "2006-01-02 15:04 MST", TimeZone: "Asia/Tokyo"}, expected: map[string]string{"v": "2024-03-01 21:30 JST"}},
+		{name: "Time from epoch millis", path: "epoch", format: &Format{Type: TypeTime, InputLayout: "unix_ms"}, expected: map[string]string{"v": "2024-03-01T12:30:00Z"}},
+		{name: "Missing", path: "nope", format: &Format{}, expected: map[string]string{"v": ""}},
+		{name: "Missing with default", path: "nope", format: &Format{Default: &none}, expected: map[string]string{"v": "none"}},
+		{name: "Missing omitted", path: "nope", format: &Format{OmitMissing: true}, expected: map[string]string{}},
+		{name: "Conversion failure uses default", path: "status", format: &Format{Type: TypeInt, Default: &none}, expected: map[string]string{"v": "none"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewFieldExtractor(map[string]string{"v": test.path})
+			if test.format != nil {
+				if err := f.SetFormats(map[string]Format{"v": *test.format}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			result := f.ExtractString(input)
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Unexpected result. Got: %v, Want: %v", result, test.expected)
+			}
+		})
+	}
+}
+
+func TestFormatValidate(t *testing.T) {
+	none := "none"
+
+	tests := []struct {
+		name   string
+		format Format
+		err    string
+	}{
+		{name: "Valid", format: Format{Type: TypeTime, TimeZone: "Europe/Paris"}},
+		{name: "Unknown type", format: Format{Type: "bool"}, err: "unknown label type"},
+		{name: "Default and omit", format: Format{Default: &none, OmitMissing: true}, err: "cannot both be set"},
+		{name: "Unknown timezone", format: Format{TimeZone: "Mars/Olympus"}, err: "invalid timezone"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.format.Validate()
+			if (err == nil) != (test.err == "") || (err != nil && !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("Unexpected result. Got: %v, Want: %v", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package label
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Funcs are the functions available to annotation templates, on top of the
+// text/template builtins. Arguments follow the pipeline convention, so the
+// value being worked on comes last: {{ .message | trunc 80 }}.
+var Funcs = template.FuncMap{
+	"upper":            strings.ToUpper,
+	"lower":            strings.ToLower,
+	"trunc":            trunc,
+	"replace":          replace,
+	"regexReplace":     regexReplace,
+	"default":          defaultValue,
+	"join":             join,
+	"toJson":           toJSON,
+	"fromJson":         fromJSON,
+	"formatTime":       formatTime,
+	"parseTime":        parseTime,
+	"humanize":         humanize,
+	"humanizeDuration": humanizeDuration,
+	"queryEscape":      url.QueryEscape,
+}
+
+// trunc cuts s to at most n characters.
+func trunc(n int, s string) string {
+	r := []rune(s)
+	if n < 0 {
+		n = 0
+	}
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+func replace(old string, new string, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+// regexps caches the compiled patterns of regexReplace, which templates
+// call for every message with the same few patterns.
+var regexps sync.Map
+
+func regexReplace(pattern string, repl string, s string) (string, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp).ReplaceAllString(s, repl), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	regexps.Store(pattern, re)
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// defaultValue returns def if v is missing or empty.
+func defaultValue(def interface{}, v interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return def
+		}
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return def
+		}
+	}
+	return v
+}
+
+// join joins the elements of list, a slice of any type, with sep.
+func join(sep string, list interface{}) (string, error) {
+	switch l := list.(type) {
+	case []string:
+		return strings.Join(l, sep), nil
+	case string:
+		return l, nil
+	}
+
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: cannot join %T", list)
+	}
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func fromJSON(s string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// formatTime formats t with layout. t may be a time.Time, an RFC 3339
+// string or Unix seconds.
+func formatTime(layout string, t interface{}) (string, error) {
+	tm, err := toTime(t)
+	if err != nil {
+		return "", err
+	}
+	return tm.Format(layout), nil
+}
+
+func parseTime(layout string, s string) (time.Time, error) {
+	return time.Parse(layout, s)
+}
+
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		return *t, nil
+	case string:
+		if tm, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return tm, nil
+		}
+	}
+
+	f, err := toFloat(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot use %v as a time", v)
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case string:
+		return strconv.ParseFloat(n, 64)
+	case time.Duration:
+		return n.Seconds(), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("cannot use %v as a number", v)
+}
+
+// humanize shortens a number with an SI prefix, e.g. 1234567 to 1.235M.
+func humanize(v interface{}) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	if f == 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprintf("%.4g", f), nil
+	}
+
+	if math.Abs(f) >= 1 {
+		prefix := ""
+		for _, p := range []string{"k", "M", "G", "T", "P", "E", "Z", "Y"} {
+			if math.Abs(f) < 1000 {
+				break
+			}
+			prefix = p
+			f /= 1000
+		}
+		return fmt.Sprintf("%.4g%s", f, prefix), nil
+	}
+
+	prefix := ""
+	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+		if math.Abs(f) >= 1 {
+			break
+		}
+		prefix = p
+		f *= 1000
+	}
+	return fmt.Sprintf("%.4g%s", f, prefix), nil
+}
+
+// humanizeDuration renders seconds as e.g. 1d 2h 3m 4s.
+func humanizeDuration(v interface{}) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprintf("%.4g", f), nil
+	}
+
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+	if f < 1 {
+		if f == 0 {
+			return "0s", nil
+		}
+		return sign + time.Duration(f*float64(time.Second)).String(), nil
+	}
+
+	d := int64(f / 86400)
+	h := int64(f/3600) % 24
+	m := int64(f/60) % 60
+	s := int64(f) % 60
+
+	var parts []string
+	if d > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", d))
+	}
+	if h > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", h))
+	}
+	if m > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", m))
+	}
+	if s > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%ds", s))
+	}
+	return sign + strings.Join(parts, " "), nil
+}
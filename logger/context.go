@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// level is the global log level. It can be changed at runtime with
+// SetLevel.
+var level slog.LevelVar
+
+func Level() slog.Level {
+	return level.Level()
+}
+
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+type contextKey struct{}
+
+// logContext is what a context adds to the records logged with it.
+type logContext struct {
+	attrs []slog.Attr
+	level *slog.Level
+}
+
+func fromContext(ctx context.Context) logContext {
+	lc, _ := ctx.Value(contextKey{}).(logContext)
+	return lc
+}
+
+// With returns a context whose log records carry attrs, such as the job or
+// sink they belong to.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	lc := fromContext(ctx)
+	lc.attrs = append(append([]slog.Attr(nil), lc.attrs...), attrs...)
+	return context.WithValue(ctx, contextKey{}, lc)
+}
+
+// WithLevel returns a context whose records are logged from l up,
+// regardless of the global level.
+func WithLevel(ctx context.Context, l slog.Level) context.Context {
+	lc := fromContext(ctx)
+	lc.level = &l
+	return context.WithValue(ctx, contextKey{}, lc)
+}
+
+// LevelFrom returns the level set on ctx by WithLevel, if any.
+func LevelFrom(ctx context.Context) (slog.Level, bool) {
+	if lc := fromContext(ctx); lc.level != nil {
+		return *lc.level, true
+	}
+	return 0, false
+}
+
+// contextHandler filters records by the level of their context, masks
+// secrets in their message and adds the context's attributes and trace and
+// span IDs to them.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	if lc := fromContext(ctx); lc.level != nil {
+		return l >= *lc.level
+	}
+	return l >= level.Level()
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Message = Redact(r.Message)
+	r.AddAttrs(fromContext(ctx).attrs...)
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,158 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// logfmtHandler writes records as logfmt lines:
+//
+//	time=2024-05-01T10:00:00Z level=info msg="job started" job=auth
+//
+// Levels are lower case, times RFC 3339 and groups flattened into dotted
+// keys. Values are quoted only when they have to be.
+type logfmtHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	opts   slog.HandlerOptions
+	attrs  []byte
+	groups []string
+}
+
+func newLogfmtHandler(w io.Writer, opts *slog.HandlerOptions) *logfmtHandler {
+	h := &logfmtHandler{mu: &sync.Mutex{}, w: w}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+func (h *logfmtHandler) Enabled(_ context.Context, l slog.Level) bool {
+	min := slog.LevelInfo
+	if h.opts.Level != nil {
+		min = h.opts.Level.Level()
+	}
+	return l >= min
+}
+
+func (h *logfmtHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf []byte
+	if !r.Time.IsZero() {
+		buf = h.appendAttr(buf, nil, slog.Time(slog.TimeKey, r.Time))
+	}
+	buf = h.appendAttr(buf, nil, slog.String(slog.LevelKey, strings.ToLower(r.Level.String())))
+	buf = h.appendAttr(buf, nil, slog.String(slog.MessageKey, r.Message))
+	buf = append(buf, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		buf = h.appendAttr(buf, h.groups, a)
+		return true
+	})
+	if len(buf) > 0 {
+		buf[len(buf)-1] = '\n'
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := h.w.Write(buf)
+	return err
+}
+
+func (h *logfmtHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]byte(nil), h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = h.appendAttr(h2.attrs, h.groups, a)
+	}
+	return &h2
+}
+
+func (h *logfmtHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(append([]string(nil), h.groups...), name)
+	return &h2
+}
+
+// appendAttr appends a, and the members of a group, as key=value pairs
+// followed by a space.
+func (h *logfmtHandler) appendAttr(buf []byte, groups []string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			groups = append(append([]string(nil), groups...), a.Key)
+		}
+		for _, m := range a.Value.Group() {
+			buf = h.appendAttr(buf, groups, m)
+		}
+		return buf
+	}
+
+	if h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) {
+		return buf
+	}
+
+	key := a.Key
+	if len(groups) > 0 {
+		key = strings.Join(groups, ".") + "." + key
+	}
+	buf = append(buf, logfmtKey(key)...)
+	buf = append(buf, '=')
+	buf = append(buf, logfmtValue(a.Value)...)
+	return append(buf, ' ')
+}
+
+// logfmtKey replaces the characters a logfmt key can't hold.
+func logfmtKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '=' || r == '"' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+func logfmtValue(v slog.Value) string {
+	var s string
+	switch v.Kind() {
+	case slog.KindTime:
+		s = v.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			s = err.Error()
+		} else {
+			s = v.String()
+		}
+	default:
+		s = v.String()
+	}
+
+	if needsQuote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r == '=' || r == '"' || r == '\\' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
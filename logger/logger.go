@@ -1,22 +1,271 @@
 package logger
 
 import (
+	"context"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatText   = "text"
+	FormatLogfmt = "logfmt"
 )
 
-var Logger = slog.Default()
+// DefaultMaxSize is the size in megabytes a log file rotates at.
+const DefaultMaxSize = 100
 
 type LogConfig struct {
-	LogLevel   string `json:"logLevel"`
-	OutputPath string `json:"outputPath"`
+	// LogLevel is debug, info, warn or error. Defaults to info.
+	LogLevel string `json:"logLevel"`
+
+	// Format is json, text, the key=value lines of log/slog, or logfmt,
+	// which has lower case levels and flattens groups into dotted keys.
+	// Defaults to json.
+	Format string `json:"format"`
+
+	// OutputType is stdout, stderr, file or syslog. Defaults to stdout.
 	OutputType string `json:"outputType"`
+
+	// OutputPath is the log file, or the syslog address such as
+	// udp://localhost:514. Without an address the local syslog is used.
+	OutputPath string `json:"outputPath"`
+
+	Rotation RotationConfig `json:"rotation"`
+}
+
+// RotationConfig rotates a log file by size and, optionally, by time.
+type RotationConfig struct {
+	// MaxSize is the size in megabytes a file rotates at.
+	MaxSize int `json:"maxSize"`
+
+	// Interval, in seconds, also rotates the file on a schedule.
+	Interval int `json:"interval"`
+
+	// MaxAge in days and MaxBackups limit the rotated files kept. Zero
+	// keeps them all.
+	MaxAge     int  `json:"maxAge"`
+	MaxBackups int  `json:"maxBackups"`
+	Compress   bool `json:"compress"`
+}
+
+func (c LogConfig) Validate() error {
+	if _, err := ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	switch c.Format {
+	case "", FormatJSON, FormatText, FormatLogfmt:
+	default:
+		return errors.Errorf("unknown log format %q", c.Format)
+	}
+
+	switch c.OutputType {
+	case "", OutputStdout, OutputStderr, OutputSyslog:
+	case OutputFile:
+		if c.OutputPath == "" {
+			return errors.New("outputPath is required with the file output")
+		}
+	default:
+		return errors.Errorf("unknown log outputType %q", c.OutputType)
+	}
+
+	return c.Rotation.Validate()
+}
+
+func (c RotationConfig) Validate() error {
+	if c.MaxSize < 0 || c.Interval < 0 || c.MaxAge < 0 || c.MaxBackups < 0 {
+		return errors.New("rotation settings must not be negative")
+	}
+	return nil
+}
+
+// ParseLevel parses a level name, defaulting to info.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(strings.ToLower(s))); err != nil {
+		return l, errors.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+var (
+	outputMu sync.Mutex
+	output   io.Closer
+)
+
+// InitLogger replaces the default logger with one set up by cfg. On error
+// the previous logger is kept.
+func InitLogger(cfg LogConfig) error {
+	lvl, err := ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	w, closer, err := openOutput(cfg)
+	if err != nil {
+		return err
+	}
+
+	// levels are checked by contextHandler, so the handler itself takes
+	// everything
+	opts := &slog.HandlerOptions{Level: slog.Level(-8), ReplaceAttr: redactAttr}
+
+	var h slog.Handler
+	switch cfg.Format {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatLogfmt:
+		h = newLogfmtHandler(w, opts)
+	default:
+		h = slog.NewJSONHandler(w, opts)
+	}
+	if lw, ok := w.(levelWriter); ok {
+		h = leveledHandler{h, lw}
+	}
+	level.Set(lvl)
+	slog.SetDefault(slog.New(contextHandler{h}))
+
+	outputMu.Lock()
+	prev := output
+	output = closer
+	outputMu.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+	return nil
+}
+
+// levelWriter is an output that writes each record at its level, like
+// syslog with its priorities.
+type levelWriter interface {
+	// writeAt calls write, which writes one record, at level l.
+	writeAt(l slog.Level, write func() error) error
+}
+
+// leveledHandler tells a levelWriter the level of each record it handles.
+type leveledHandler struct {
+	slog.Handler
+	w levelWriter
+}
+
+func (h leveledHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.w.writeAt(r.Level, func() error { return h.Handler.Handle(ctx, r) })
+}
+
+func (h leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return leveledHandler{h.Handler.WithAttrs(attrs), h.w}
+}
+
+func (h leveledHandler) WithGroup(name string) slog.Handler {
+	return leveledHandler{h.Handler.WithGroup(name), h.w}
+}
+
+// openOutput returns the writer for cfg and what closes it, if anything.
+func openOutput(cfg LogConfig) (io.Writer, io.Closer, error) {
+	switch cfg.OutputType {
+	case OutputStderr:
+		return os.Stderr, nil, nil
+
+	case OutputFile:
+		f := OpenRotating(cfg.OutputPath, cfg.Rotation)
+		return f, f, nil
+
+	case OutputSyslog:
+		w, err := openSyslog(cfg.OutputPath)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to open syslog")
+		}
+		return w, w, nil
+
+	default:
+		return os.Stdout, nil, nil
+	}
+}
+
+// rotator is a file that rotates by size and every interval, if set.
+type rotator struct {
+	f    *lumberjack.Logger
+	stop chan struct{}
+	once sync.Once
+}
+
+// OpenRotating returns a file at path rotated as set by cfg. The file is
+// created on the first write.
+func OpenRotating(path string, cfg RotationConfig) io.WriteCloser {
+	f := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    cfg.MaxSize,
+		MaxAge:     cfg.MaxAge,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   cfg.Compress,
+		LocalTime:  true,
+	}
+	if f.MaxSize == 0 {
+		f.MaxSize = DefaultMaxSize
+	}
+
+	r := &rotator{f: f, stop: make(chan struct{})}
+	if cfg.Interval > 0 {
+		go r.run(time.Duration(cfg.Interval) * time.Second)
+	}
+	return r
+}
+
+func (r *rotator) Write(p []byte) (int, error) {
+	return r.f.Write(p)
+}
+
+func (r *rotator) run(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+			if err := r.f.Rotate(); err != nil {
+				slog.Error("failed to rotate log file", "path", r.f.Filename, "error", err)
+			}
+		}
+	}
+}
+
+// Sync flushes the current file to disk. Lumberjack doesn't expose its
+// file, so it is synced through a descriptor of its own.
+func (r *rotator) Sync() error {
+	f, err := os.OpenFile(r.f.Filename, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
 }
 
-// TODO: log level
-// TODO: log output
-// TODO: log rotation
-func InitLogger(LogConfig) {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	slog.SetDefault(logger)
+func (r *rotator) Close() error {
+	r.once.Do(func() { close(r.stop) })
+	return r.f.Close()
 }
@@ -0,0 +1,132 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  LogConfig
+		err  string
+	}{
+		{name: "Defaults", cfg: LogConfig{}},
+		{name: "File", cfg: LogConfig{LogLevel: "DEBUG", Format: "logfmt", OutputType: "file", OutputPath: "app.log"}},
+		{name: "Unknown level", cfg: LogConfig{LogLevel: "verbose"}, err: `unknown log level "verbose"`},
+		{name: "Unknown format", cfg: LogConfig{Format: "xml"}, err: `unknown log format "xml"`},
+		{name: "File without path", cfg: LogConfig{OutputType: "file"}, err: "outputPath is required"},
+		{name: "Negative rotation", cfg: LogConfig{Rotation: RotationConfig{MaxAge: -1}}, err: "rotation settings must not be negative"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cfg.Validate()
+			if (err == nil) != (test.err == "") || (err != nil && !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("Unexpected result. Got: %v, Want: %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestInitLoggerFile(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	err := InitLogger(LogConfig{LogLevel: "warn", Format: "text", OutputType: "file", OutputPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Info("hidden")
+	slog.Warn("shown", "key", "value")
+	InitLogger(LogConfig{})
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(raw)
+	if strings.Contains(got, "hidden") || !strings.Contains(got, `msg=shown key=value`) {
+		t.Errorf("Unexpected result. Got: %s", got)
+	}
+}
+
+func TestContextHandler(t *testing.T) {
+	defer SetLevel(Level())
+	SetLevel(slog.LevelWarn)
+
+	var buf strings.Builder
+	log := slog.New(contextHandler{slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.Level(-8)})})
+
+	ctx := With(context.Background(), slog.String("job", "job1"))
+	log.InfoContext(ctx, "hidden")
+	log.DebugContext(WithLevel(ctx, slog.LevelDebug), "shown")
+
+	got := buf.String()
+	if strings.Contains(got, "hidden") || !strings.Contains(got, "msg=shown job=job1") {
+		t.Errorf("Unexpected result. Got: %s", got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	defer SetSecrets(nil)
+	SetSecrets([]string{"hunter2"})
+
+	var buf strings.Builder
+	log := slog.New(contextHandler{slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: redactAttr})})
+
+	log.Info("login with hunter2",
+		"value", "pw=hunter2",
+		"err", errors.New("auth hunter2 failed"),
+		"list", []string{"a", "hunter2"},
+		"count", 2,
+	)
+
+	got := buf.String()
+	if strings.Contains(got, "hunter2") {
+		t.Errorf("Secret not redacted: %s", got)
+	}
+	for _, want := range []string{`msg="login with ******"`, `value="pw=******"`, `err="auth ****** failed"`, `list="[a ******]"`, "count=2"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Unexpected result. Got: %v, Want: %v", got, want)
+		}
+	}
+}
+
+func TestRedactGroup(t *testing.T) {
+	defer SetSecrets(nil)
+	SetSecrets([]string{"hunter2"})
+
+	a := redactAttr(nil, slog.Group("auth", slog.String("user", "bob"), slog.Group("basic", slog.String("password", "hunter2"))))
+
+	want := slog.Group("auth", slog.String("user", "bob"), slog.Group("basic", slog.String("password", Redacted)))
+	if !a.Equal(want) {
+		t.Errorf("Unexpected result. Got: %v, Want: %v", a, want)
+	}
+}
+
+func TestLogfmtHandler(t *testing.T) {
+	defer SetSecrets(nil)
+	SetSecrets([]string{"hunter2"})
+
+	var buf strings.Builder
+	log := slog.New(newLogfmtHandler(&buf, &slog.HandlerOptions{ReplaceAttr: redactAttr}))
+
+	log.With("job", "auth").WithGroup("es").Warn("query failed",
+		"status", 503,
+		"error", errors.New(`bad "token" hunter2`),
+		slog.Group("req", "index", "logs-*", "body", ""),
+	)
+
+	got := buf.String()
+	want := `level=warn msg="query failed" job=auth es.status=503 es.error="bad \"token\" ******" es.req.index=logs-* es.req.body=""` + "\n"
+	if !strings.HasPrefix(got, "time=") || !strings.HasSuffix(got, want) {
+		t.Errorf("Unexpected result. Got: %s, Want: %s", got, want)
+	}
+}
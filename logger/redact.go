@@ -0,0 +1,73 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secret values in logs and config dumps.
+const Redacted = "******"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// SetSecrets replaces the set of values that are masked in every log record.
+func SetSecrets(values []string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	secrets = append([]string(nil), values...)
+}
+
+// Redact masks every known secret in s.
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+
+	for _, v := range secrets {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	return s
+}
+
+// redactAttr is a slog ReplaceAttr hook masking secrets in the values of
+// attributes, whatever their key, and in the members of groups. Values that
+// are neither strings nor errors are replaced by their redacted text only
+// if they hold a secret.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	secretsMu.RLock()
+	none := len(secrets) == 0
+	secretsMu.RUnlock()
+	if none {
+		return a
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(a.Value.String()))
+	case slog.KindGroup:
+		members := a.Value.Group()
+		redacted := make([]slog.Attr, len(members))
+		for i, m := range members {
+			redacted[i] = redactAttr(append(groups, a.Key), m)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			a.Value = slog.StringValue(Redact(v.Error()))
+		default:
+			if s := fmt.Sprint(v); Redact(s) != s {
+				a.Value = slog.StringValue(Redact(s))
+			}
+		}
+	case slog.KindLogValuer:
+		a.Value = a.Value.Resolve()
+		return redactAttr(groups, a)
+	}
+	return a
+}
@@ -0,0 +1,67 @@
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"io"
+	"log/slog"
+	"log/syslog"
+	"net/url"
+	"sync"
+)
+
+// openSyslog connects to the syslog at addr, such as udp://localhost:514,
+// or to the local one if addr is empty.
+func openSyslog(addr string) (io.WriteCloser, error) {
+	network, raddr := "", ""
+	if addr != "" {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "alert-fetcher")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogOutput{w: w}, nil
+}
+
+// syslogOutput writes records at the syslog priority of their level.
+type syslogOutput struct {
+	mu    sync.Mutex
+	w     *syslog.Writer
+	level slog.Level
+}
+
+func (o *syslogOutput) writeAt(l slog.Level, write func() error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.level = l
+	return write()
+}
+
+func (o *syslogOutput) Write(p []byte) (int, error) {
+	var err error
+	switch m := string(p); {
+	case o.level >= slog.LevelError:
+		err = o.w.Err(m)
+	case o.level >= slog.LevelWarn:
+		err = o.w.Warning(m)
+	case o.level >= slog.LevelInfo:
+		err = o.w.Info(m)
+	default:
+		err = o.w.Debug(m)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (o *syslogOutput) Close() error {
+	return o.w.Close()
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package logger
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+func openSyslog(string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
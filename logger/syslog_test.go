@@ -0,0 +1,51 @@
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogPriority(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := InitLogger(LogConfig{LogLevel: "debug", Format: "text", OutputType: "syslog", OutputPath: "udp://" + conn.LocalAddr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	defer InitLogger(LogConfig{})
+
+	// the facility is daemon, 3 << 3
+	tests := []struct {
+		log  func(string, ...interface{})
+		want string
+	}{
+		{slog.Error, "<27>"},
+		{slog.Warn, "<28>"},
+		{slog.Info, "<30>"},
+		{slog.Debug, "<31>"},
+	}
+
+	buf := make([]byte, 1024)
+	for _, test := range tests {
+		test.log("message")
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); !strings.HasPrefix(got, test.want) {
+			t.Errorf("Unexpected result. Got: %v, Want: %v", got, test.want)
+		}
+	}
+}
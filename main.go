@@ -1,7 +1,28 @@
 package main
 
-import "github.com/wanmail/alert-fetcher/app"
+import (
+	"os"
+
+	"github.com/wanmail/alert-fetcher/app"
+)
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "test":
+			app.Test(os.Args[2:])
+			return
+		case "validate":
+			app.Validate(os.Args[2:])
+			return
+		case "deadletter":
+			app.DeadLetter(os.Args[2:])
+			return
+		case "audit":
+			app.Audit(os.Args[2:])
+			return
+		}
+	}
+
 	app.Start()
 }
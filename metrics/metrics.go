@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "alert_fetcher"
+
+// Registry holds every metric of the process. It is separate from the
+// default registry so that tests and embedding programs start clean.
+var Registry = prometheus.NewRegistry()
+
+var (
+	FetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_fetch_duration_seconds",
+		Help:      "Time taken to fetch and queue one window of a job.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"job"})
+
+	Hits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_hits_total",
+		Help:      "Documents returned by the source of a job.",
+	}, []string{"job"})
+
+	FetchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_errors_total",
+		Help:      "Ticks of a job that failed.",
+	}, []string{"job"})
+
+	LastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "job_last_success_timestamp_seconds",
+		Help:      "Time of the last tick of a job that succeeded.",
+	}, []string{"job"})
+
+	TemplateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "template_errors_total",
+		Help:      "Annotation templates that failed to render.",
+	}, []string{"job"})
+
+	ExtractionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "extraction_errors_total",
+		Help:      "Labels whose field was missing from a document.",
+	}, []string{"job"})
+
+	ExpressionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expression_errors_total",
+		Help:      "Label expressions that failed on a document.",
+	}, []string{"job"})
+
+	SendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sink_send_duration_seconds",
+		Help:      "Time taken by a single send attempt of a sink.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"sink"})
+
+	Sent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_sent_total",
+		Help:      "Messages a sink sent.",
+	}, []string{"sink"})
+
+	SendFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_failures_total",
+		Help:      "Messages a sink gave up on after retrying.",
+	}, []string{"sink"})
+
+	Lost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_lost_total",
+		Help:      "Messages a sink gave up on with no dead-letter store to keep them.",
+	}, []string{"sink"})
+
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_retries_total",
+		Help:      "Send attempts of a sink that were retries.",
+	}, []string{"sink"})
+
+	Dropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_dropped_total",
+		Help:      "Messages dropped because the queue of a sink was full.",
+	}, []string{"sink"})
+
+	ReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last config reload succeeded.",
+	})
+
+	ReloadTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Time of the last successful config load.",
+	})
+
+	Reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Config reloads by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		FetchDuration, Hits, FetchErrors, LastSuccess,
+		TemplateErrors, ExtractionErrors, ExpressionErrors,
+		SendDuration, Sent, SendFailures, Lost, Retries, Dropped,
+		ReloadSuccess, ReloadTime, Reloads,
+	)
+}
+
+// DeleteJob removes the series of a job that is no longer configured.
+func DeleteJob(name string) {
+	for _, v := range []interface{ DeleteLabelValues(...string) bool }{
+		FetchDuration, Hits, FetchErrors, LastSuccess, TemplateErrors, ExtractionErrors, ExpressionErrors,
+	} {
+		v.DeleteLabelValues(name)
+	}
+}
+
+// DeleteSink removes the series of a sink that is no longer configured.
+func DeleteSink(name string) {
+	for _, v := range []interface{ DeleteLabelValues(...string) bool }{
+		SendDuration, Sent, SendFailures, Lost, Retries, Dropped,
+	} {
+		v.DeleteLabelValues(name)
+	}
+}
+
+// Handler serves the metrics in Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
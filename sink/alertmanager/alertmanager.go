@@ -1,12 +1,16 @@
 package alertmanager
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 
+	"github.com/go-openapi/runtime"
 	"github.com/go-openapi/strfmt"
 	"github.com/pkg/errors"
 	"github.com/prometheus/alertmanager/api/v2/client"
 	"github.com/prometheus/alertmanager/api/v2/client/alert"
+	"github.com/prometheus/alertmanager/api/v2/client/general"
 	"github.com/prometheus/alertmanager/api/v2/models"
 	"github.com/wanmail/alert-fetcher/label"
 )
@@ -16,6 +20,13 @@ type ClientConfig struct {
 	BasePath string `json:"basePath"`
 }
 
+func (c ClientConfig) Validate() error {
+	if c.Host == "" {
+		return errors.New("host is required")
+	}
+	return nil
+}
+
 type Client struct {
 	client *client.AlertmanagerAPI
 }
@@ -29,9 +40,34 @@ func NewClient(cfg ClientConfig) (*Client, error) {
 	return &Client{client: c}, nil
 }
 
+// StatusError is returned when Alertmanager answers with an error status.
+type StatusError struct {
+	Code int
+	Err  error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("alertmanager returned [%d]: %v", e.Code, e.Err)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StatusError) StatusCode() int {
+	return e.Code
+}
+
+// Send posts msg as a single alert. Failures with a status code are
+// returned as a *StatusError.
 func (c *Client) Send(msg label.Message) error {
-	if name, ok := msg.Labels["alertname"]; (!ok) || name == "" {
-		msg.Labels["alertname"] = msg.ID
+	// the message may be shared with other sinks, so never modify its labels
+	labels := make(map[string]string, len(msg.Labels)+1)
+	for k, v := range msg.Labels {
+		labels[k] = v
+	}
+	if name, ok := labels["alertname"]; (!ok) || name == "" {
+		labels["alertname"] = msg.ID
 	}
 
 	ok, err := c.client.Alert.PostAlerts(
@@ -40,7 +76,7 @@ func (c *Client) Send(msg label.Message) error {
 				{
 					Annotations: msg.Annotations,
 					Alert: models.Alert{
-						Labels: msg.Labels,
+						Labels: labels,
 					},
 				},
 			},
@@ -48,16 +84,40 @@ func (c *Client) Send(msg label.Message) error {
 	)
 
 	if err != nil {
-		return err
+		return statusError(err)
 	}
 
 	if !ok.IsSuccess() {
-		return errors.Errorf("alertmanager returned [%d][%s]", ok.Code(), ok.Error())
+		return &StatusError{Code: ok.Code(), Err: errors.New(ok.Error())}
 	}
 
 	return nil
 }
 
+// Check asks Alertmanager for its status.
+func (c *Client) Check(ctx context.Context) error {
+	if _, err := c.client.General.GetStatus(general.NewGetStatusParamsWithContext(ctx)); err != nil {
+		return errors.Wrap(statusError(err), "failed to get status")
+	}
+	return nil
+}
+
+// statusError attaches the status code of a failed response to err.
+// Connection errors are returned unchanged.
+func statusError(err error) error {
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) {
+		return &StatusError{Code: coded.Code(), Err: err}
+	}
+
+	var apiErr *runtime.APIError
+	if errors.As(err, &apiErr) {
+		return &StatusError{Code: apiErr.Code, Err: err}
+	}
+
+	return err
+}
+
 func (c *Client) AsyncSend(ch <-chan label.Message) {
 	for msg := range ch {
 		err := c.Send(msg)
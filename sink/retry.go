@@ -0,0 +1,134 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/label"
+)
+
+const (
+	DefaultMaxAttempts    = 3
+	DefaultInitialBackoff = 1
+	DefaultMaxBackoff     = 30
+	DefaultMultiplier     = 2
+	DefaultJitter         = 0.2
+)
+
+// DefaultRetryableStatus are the status codes retried unless configured
+// otherwise. Errors without a status code, such as connection failures,
+// are always retried.
+var DefaultRetryableStatus = []int{429, 500, 502, 503, 504}
+
+// backoffUnit is what backoffs are counted in. Tests shorten it.
+var backoffUnit = time.Second
+
+// StatusCoder is implemented by sink errors that carry the status code of
+// a failed request.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// RetryConfig sets how a failed send is retried. Backoffs are in seconds.
+// Jitter is a pointer so that an explicit 0 turns it off.
+type RetryConfig struct {
+	MaxAttempts     int      `json:"maxAttempts"`
+	InitialBackoff  int      `json:"initialBackoff"`
+	MaxBackoff      int      `json:"maxBackoff"`
+	Multiplier      float64  `json:"multiplier"`
+	Jitter          *float64 `json:"jitter"`
+	RetryableStatus []int    `json:"retryableStatus"`
+}
+
+// WithDefaults fills in the unset fields.
+func (c RetryConfig) WithDefaults() RetryConfig {
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.InitialBackoff == 0 {
+		c.InitialBackoff = DefaultInitialBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.Multiplier == 0 {
+		c.Multiplier = DefaultMultiplier
+	}
+	if c.Jitter == nil {
+		jitter := DefaultJitter
+		c.Jitter = &jitter
+	}
+	if c.RetryableStatus == nil {
+		c.RetryableStatus = DefaultRetryableStatus
+	}
+	return c
+}
+
+func (c RetryConfig) Validate() error {
+	switch {
+	case c.MaxAttempts < 0:
+		return pkgerrors.Errorf("retry maxAttempts must not be negative, got %d", c.MaxAttempts)
+	case c.InitialBackoff < 0 || c.MaxBackoff < 0:
+		return pkgerrors.New("retry backoffs must not be negative")
+	case c.Multiplier != 0 && c.Multiplier < 1:
+		return pkgerrors.Errorf("retry multiplier must be at least 1, got %g", c.Multiplier)
+	case c.Jitter != nil && (*c.Jitter < 0 || *c.Jitter > 1):
+		return pkgerrors.Errorf("retry jitter must be between 0 and 1, got %g", *c.Jitter)
+	}
+	return nil
+}
+
+// Backoff returns the wait before attempt n+1, n starting at 1.
+func (c RetryConfig) Backoff(n int) time.Duration {
+	d := float64(c.InitialBackoff) * math.Pow(c.Multiplier, float64(n-1))
+	d = math.Min(d, float64(c.MaxBackoff))
+	if c.Jitter != nil {
+		d *= 1 + *c.Jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(d * float64(backoffUnit))
+}
+
+// Retryable reports whether a send that failed with err may succeed later.
+func (c RetryConfig) Retryable(err error) bool {
+	var sc StatusCoder
+	if !errors.As(err, &sc) {
+		return true
+	}
+
+	for _, code := range c.RetryableStatus {
+		if sc.StatusCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// SendWithRetry sends msg, retrying retryable failures with exponential
+// backoff until the attempts run out or ctx is done. It returns how many
+// attempts were made and the last error.
+func SendWithRetry(ctx context.Context, s Sink, msg label.Message, cfg RetryConfig) (int, error) {
+	cfg = cfg.WithDefaults()
+
+	var err error
+	for n := 1; ; n++ {
+		if err = s.Send(msg); err == nil {
+			return n, nil
+		}
+		if n >= cfg.MaxAttempts || !cfg.Retryable(err) {
+			return n, err
+		}
+
+		t := time.NewTimer(cfg.Backoff(n))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return n, err
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wanmail/alert-fetcher/label"
+)
+
+type statusError int
+
+func (e statusError) Error() string   { return "status error" }
+func (e statusError) StatusCode() int { return int(e) }
+
+// fakeSink fails with errs in turn, then succeeds.
+type fakeSink struct {
+	errs  []error
+	calls int
+}
+
+func (s *fakeSink) Send(label.Message) error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func (s *fakeSink) AsyncSend(<-chan label.Message) {}
+
+func TestSendWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		fail     bool
+	}{
+		{
+			name:     "Success",
+			attempts: 1,
+		},
+		{
+			name:     "Retryable status then success",
+			errs:     []error{statusError(503)},
+			attempts: 2,
+		},
+		{
+			name:     "Connection error then success",
+			errs:     []error{errors.New("connection refused")},
+			attempts: 2,
+		},
+		{
+			name:     "Non retryable status",
+			errs:     []error{statusError(400)},
+			attempts: 1,
+			fail:     true,
+		},
+		{
+			name:     "Attempts exhausted",
+			errs:     []error{statusError(500), statusError(500), statusError(500)},
+			attempts: 2,
+			fail:     true,
+		},
+	}
+
+	defer func(unit time.Duration) { backoffUnit = unit }(backoffUnit)
+	backoffUnit = time.Millisecond
+
+	cfg := RetryConfig{MaxAttempts: 2, InitialBackoff: 1}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &fakeSink{errs: test.errs}
+			attempts, err := SendWithRetry(context.Background(), s, label.Message{ID: "1"}, cfg)
+			if attempts != test.attempts || s.calls != test.attempts {
+				t.Errorf("Unexpected result. Got: %d attempts, Want: %d", attempts, test.attempts)
+			}
+			if (err != nil) != test.fail {
+				t.Errorf("Unexpected result. Got: %v, Want failure: %v", err, test.fail)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	jitter := 0.0
+	cfg := RetryConfig{InitialBackoff: 1, MaxBackoff: 5, Jitter: &jitter}.WithDefaults()
+
+	for n, want := range map[int]float64{1: 1, 2: 2, 3: 4, 4: 5, 10: 5} {
+		if got := cfg.Backoff(n).Seconds(); got != want {
+			t.Errorf("Unexpected result for attempt %d. Got: %v, Want: %v", n, got, want)
+		}
+	}
+
+	cfg.Jitter = nil
+	if got := *cfg.WithDefaults().Jitter; got != DefaultJitter {
+		t.Errorf("Unexpected result. Got: %v, Want: %v", got, DefaultJitter)
+	}
+}
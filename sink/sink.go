@@ -1,9 +1,11 @@
 package sink
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/internal/plugin"
 	"github.com/wanmail/alert-fetcher/label"
 	"github.com/wanmail/alert-fetcher/sink/alertmanager"
 )
@@ -11,6 +13,66 @@ import (
 type SinkConfig struct {
 	SinkType   string          `json:"sinkType"`
 	SinkConfig json.RawMessage `json:"sinkConfig"`
+
+	Queue QueueConfig `json:"queue"`
+	Retry RetryConfig `json:"retry"`
+}
+
+// Overflow policies for a full sink queue.
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+	OverflowSpill      = "spill"
+)
+
+const (
+	DefaultQueueSize    = 1000
+	DefaultQueueWorkers = 1
+)
+
+// QueueConfig bounds the messages waiting for a sink and sets how many are
+// sent concurrently.
+type QueueConfig struct {
+	Size      int    `json:"size"`
+	Overflow  string `json:"overflow"`
+	SpillPath string `json:"spillPath"`
+	Workers   int    `json:"workers"`
+}
+
+// WithDefaults fills in the unset fields.
+func (c QueueConfig) WithDefaults() QueueConfig {
+	if c.Size == 0 {
+		c.Size = DefaultQueueSize
+	}
+	if c.Overflow == "" {
+		c.Overflow = OverflowBlock
+	}
+	if c.Workers == 0 {
+		c.Workers = DefaultQueueWorkers
+	}
+	return c
+}
+
+func (c QueueConfig) Validate() error {
+	if c.Size < 0 {
+		return errors.Errorf("queue size must not be negative, got %d", c.Size)
+	}
+	if c.Workers < 0 {
+		return errors.Errorf("queue workers must not be negative, got %d", c.Workers)
+	}
+
+	switch c.Overflow {
+	case "", OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+	case OverflowSpill:
+		if c.SpillPath == "" {
+			return errors.New("queue spillPath is required with the spill overflow policy")
+		}
+	default:
+		return errors.Errorf("unknown queue overflow policy %q", c.Overflow)
+	}
+
+	return nil
 }
 
 type Sink interface {
@@ -18,16 +80,44 @@ type Sink interface {
 	AsyncSend(<-chan label.Message)
 }
 
+// Checker is implemented by sinks that can test their connection without
+// sending anything.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
 func New(cfg SinkConfig) (sink Sink, err error) {
 	switch cfg.SinkType {
 	case "alertmanager":
 		c := alertmanager.ClientConfig{}
-		if err = json.Unmarshal(cfg.SinkConfig, &c); err != nil {
+		if err = plugin.Decode(cfg.SinkConfig, &c, "sinkConfig"); err != nil {
 			return
 		}
 		return alertmanager.NewClient(c)
 
 	default:
-		return nil, errors.Errorf("invalid source type %s", cfg.SinkType)
+		return nil, errors.Errorf("invalid sink type %s", cfg.SinkType)
+	}
+}
+
+// Validate checks cfg without connecting to anything.
+func Validate(cfg SinkConfig) error {
+	switch cfg.SinkType {
+	case "alertmanager":
+		c := alertmanager.ClientConfig{}
+		if err := plugin.Decode(cfg.SinkConfig, &c, "sinkConfig"); err != nil {
+			return err
+		}
+		if err := c.Validate(); err != nil {
+			return err
+		}
+
+	default:
+		return errors.Errorf("invalid sink type %q", cfg.SinkType)
+	}
+
+	if err := cfg.Queue.Validate(); err != nil {
+		return err
 	}
+	return cfg.Retry.Validate()
 }
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -22,35 +24,121 @@ type Config struct {
 type QueryConfig struct {
 	Index     string `json:"index"`
 	TimeQuery string `json:"timeQuery"`
-	Query     string `json:"query"`
+	Query     Query  `json:"query"`
 	Max       int    `json:"max"`
 }
 
+// Query is an Elasticsearch query clause. It can be written either as a
+// JSON string or directly as an object, which reads much better in YAML.
+type Query string
+
+func (q *Query) UnmarshalJSON(raw []byte) error {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		*q = Query(s)
+		return nil
+	}
+
+	if len(raw) == 0 || raw[0] != '{' {
+		return errors.New("query must be a string or an object")
+	}
+	*q = Query(raw)
+	return nil
+}
+
 type ClientConfig struct {
 	Address  string `json:"address"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
+func (c Config) Validate() error {
+	if c.Address == "" {
+		return errors.New("address is required")
+	}
+	if c.Index == "" {
+		return errors.New("index is required")
+	}
+	if c.Query == "" {
+		return errors.New("query is required")
+	}
+	if !json.Valid([]byte(c.Query)) {
+		return errors.New("query is not valid JSON")
+	}
+	if c.Max <= 0 {
+		return errors.Errorf("max must be greater than 0, got %d", c.Max)
+	}
+	return nil
+}
+
 type Client struct {
 	QueryConfig
 	client *elasticsearch.Client
+
+	conn  ClientConfig
+	close sync.Once
 }
 
-func NewElasticSource(ccfg ClientConfig, qcfg QueryConfig) (*Client, error) {
-	ec, err := elasticsearch.NewClient(
-		elasticsearch.Config{
-			Addresses: []string{ccfg.Address},
-			Username:  ccfg.Username,
-			Password:  ccfg.Password,
-		},
-	)
+// sharedClient is a client used by every job querying the same cluster, so
+// that they share its connection pool.
+type sharedClient struct {
+	client    *elasticsearch.Client
+	transport *http.Transport
+	refs      int
+}
 
-	if err != nil {
-		return nil, err
+// clients caches one client per connection until the last job using it is
+// closed.
+var (
+	clientsMu sync.Mutex
+	clients   = map[ClientConfig]*sharedClient{}
+)
+
+func NewElasticSource(ccfg ClientConfig, qcfg QueryConfig) (*Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	sc, ok := clients[ccfg]
+	if !ok {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		ec, err := elasticsearch.NewClient(
+			elasticsearch.Config{
+				Addresses: []string{ccfg.Address},
+				Username:  ccfg.Username,
+				Password:  ccfg.Password,
+				Transport: transport,
+			},
+		)
+
+		if err != nil {
+			return nil, err
+		}
+		sc = &sharedClient{client: ec, transport: transport}
+		clients[ccfg] = sc
 	}
+	sc.refs++
 
-	return &Client{client: ec, QueryConfig: qcfg}, nil
+	return &Client{client: sc.client, QueryConfig: qcfg, conn: ccfg}, nil
+}
+
+// Close releases the client. The connections to the cluster are closed once
+// no other client uses them. Closing a client twice does nothing.
+func (c *Client) Close() error {
+	c.close.Do(func() {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+
+		sc, ok := clients[c.conn]
+		if !ok {
+			return
+		}
+		sc.refs--
+		if sc.refs == 0 {
+			delete(clients, c.conn)
+			sc.transport.CloseIdleConnections()
+		}
+	})
+	return nil
 }
 
 var timeQuery = `
@@ -120,6 +208,20 @@ func (c *Client) FetchAll(ctx context.Context, from time.Time, now time.Time) ([
 	return outs, nil
 }
 
+// Check pings the cluster.
+func (c *Client) Check(ctx context.Context) error {
+	resp, err := c.client.Ping(c.client.Ping.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to ping")
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		return errors.Errorf("failed to ping[%d]", resp.StatusCode)
+	}
+	return nil
+}
+
 // TODO: implement this
 func (c *Client) FetchOne(ctx context.Context, from time.Time, now time.Time) (map[string]interface{}, error) {
 	return nil, nil
@@ -0,0 +1,30 @@
+package elasticsearch
+
+import "testing"
+
+func TestClientSharing(t *testing.T) {
+	conn := ClientConfig{Address: "http://localhost:9200"}
+
+	c1, err := NewElasticSource(conn, QueryConfig{Index: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := NewElasticSource(conn, QueryConfig{Index: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1.client != c2.client {
+		t.Errorf("Clients of the same connection are not shared")
+	}
+
+	c1.Close()
+	c1.Close()
+	if _, ok := clients[conn]; !ok {
+		t.Errorf("Client was evicted while still in use")
+	}
+
+	c2.Close()
+	if _, ok := clients[conn]; ok {
+		t.Errorf("Unused client was not evicted")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/wanmail/alert-fetcher/internal/plugin"
 	"github.com/wanmail/alert-fetcher/source/elasticsearch"
 )
 
@@ -24,7 +25,23 @@ type BatchSource interface {
 	FetchOne(ctx context.Context, from time.Time, now time.Time) (map[string]interface{}, error)
 }
 
+// Checker is implemented by sources that can test their connection without
+// fetching anything.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Closer is implemented by sources holding connections that must be
+// released once the job using them is done.
+type Closer interface {
+	Close() error
+}
+
 type SourceConfig struct {
+	// Ref names a source preset this config extends. It is resolved when
+	// the config is loaded, so New and Validate never see it unresolved.
+	Ref string `json:"ref"`
+
 	SourceType   string          `json:"sourceType"`
 	SourceConfig json.RawMessage `json:"sourceConfig"`
 }
@@ -33,7 +50,7 @@ func New(cfg SourceConfig) (source Source, err error) {
 	switch cfg.SourceType {
 	case "elasticsearch":
 		c := elasticsearch.Config{}
-		if err = json.Unmarshal(cfg.SourceConfig, &c); err != nil {
+		if err = plugin.Decode(cfg.SourceConfig, &c, "sourceConfig"); err != nil {
 			return
 		}
 		return elasticsearch.NewElasticSource(c.ClientConfig, c.QueryConfig)
@@ -43,3 +60,18 @@ func New(cfg SourceConfig) (source Source, err error) {
 	}
 
 }
+
+// Validate checks cfg without connecting to anything.
+func Validate(cfg SourceConfig) error {
+	switch cfg.SourceType {
+	case "elasticsearch":
+		c := elasticsearch.Config{}
+		if err := plugin.Decode(cfg.SourceConfig, &c, "sourceConfig"); err != nil {
+			return err
+		}
+		return c.Validate()
+
+	default:
+		return errors.Errorf("invalid source type %q", cfg.SourceType)
+	}
+}
@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentation    = "github.com/wanmail/alert-fetcher"
+	defaultServiceName = "alert-fetcher"
+)
+
+// Config sets up OTLP/HTTP trace export. Tracing is off without an
+// endpoint.
+type Config struct {
+	// Endpoint is the host:port of the collector.
+	Endpoint string            `json:"endpoint"`
+	URLPath  string            `json:"urlPath"`
+	Insecure bool              `json:"insecure"`
+	Headers  map[string]string `json:"headers"`
+
+	ServiceName string `json:"serviceName"`
+
+	// SampleRatio is the fraction of job ticks traced. Defaults to 1.
+	SampleRatio float64 `json:"sampleRatio"`
+
+	// Annotation, if set, is the annotation the trace ID of an alert is
+	// written to.
+	Annotation string `json:"annotation"`
+}
+
+func (c Config) Validate() error {
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return errors.Errorf("tracing sampleRatio must be between 0 and 1, got %g", c.SampleRatio)
+	}
+	return nil
+}
+
+var (
+	mu         sync.Mutex
+	annotation string
+)
+
+// Init installs the global tracer provider described by cfg. The returned
+// function flushes and stops it.
+func Init(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	mu.Lock()
+	annotation = cfg.Annotation
+	mu.Unlock()
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.URLPath != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(cfg.URLPath))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create trace exporter")
+	}
+
+	name := cfg.ServiceName
+	if name == "" {
+		name = defaultServiceName
+	}
+	ratio := cfg.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(name))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer of the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+var propagator = propagation.TraceContext{}
+
+// Inject returns the span context of ctx in W3C traceparent form, so it can
+// travel with a queued message.
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Extract returns ctx with the span context encoded by Inject.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// Annotate adds the trace ID of ctx to annotations when tracing.annotation
+// is set.
+func Annotate(ctx context.Context, annotations map[string]string) map[string]string {
+	mu.Lock()
+	key := annotation
+	mu.Unlock()
+
+	sc := trace.SpanContextFromContext(ctx)
+	if key == "" || !sc.IsValid() {
+		return annotations
+	}
+
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[key] = sc.TraceID().String()
+	return annotations
+}